@@ -0,0 +1,156 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrMiddlewareNotFound is returned when there is no middleware with the given name.
+	ErrMiddlewareNotFound = errors.New("middleware not found")
+	// ErrDuplicateMiddleware is returned when middleware with the given name is already registered.
+	ErrDuplicateMiddleware = errors.New("middleware already registered")
+)
+
+// namedMiddleware is a registered middleware
+type namedMiddleware struct {
+	name string
+	fn   MiddlewareFunc
+}
+
+// middlewareChain is a composed middleware chain for a particular http.Client
+type middlewareChain struct {
+	client  *http.Client
+	handler Responder
+}
+
+// Use adds middleware to the chain which is run on processing request.
+// The middleware gets generated names, use UseNamed to register named middleware.
+// It is safe to call Use concurrently with in-flight requests, which keep
+// using the chain they started with.
+func (c *Client) Use(middleware ...MiddlewareFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	mw := make([]namedMiddleware, 0, len(middleware))
+	for _, fn := range middleware {
+		c.seq++
+		mw = append(mw, namedMiddleware{name: fmt.Sprintf("middleware-%d", c.seq), fn: fn})
+	}
+	c.update(len(c.middleware), 0, mw...)
+}
+
+// UseNamed adds named middleware to the end of the chain. The name can be used
+// to reorder or remove the middleware and to disable it for a single request (see WithoutMiddleware).
+func (c *Client) UseNamed(name string, middleware MiddlewareFunc) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.indexOf(name) >= 0 {
+		return fmt.Errorf("%w: %s", ErrDuplicateMiddleware, name)
+	}
+	c.update(len(c.middleware), 0, namedMiddleware{name: name, fn: Named(name, middleware)})
+	return nil
+}
+
+// Before inserts named middleware before the target middleware, so it runs earlier.
+func (c *Client) Before(target, name string, middleware MiddlewareFunc) error {
+	return c.insert(target, 0, name, middleware)
+}
+
+// After inserts named middleware after the target middleware, so it runs later.
+func (c *Client) After(target, name string, middleware MiddlewareFunc) error {
+	return c.insert(target, 1, name, middleware)
+}
+
+// Replace replaces the named middleware keeping its position in the chain.
+func (c *Client) Replace(name string, middleware MiddlewareFunc) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.indexOf(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrMiddlewareNotFound, name)
+	}
+	c.update(i, 1, namedMiddleware{name: name, fn: Named(name, middleware)})
+	return nil
+}
+
+// Remove removes the named middleware from the chain.
+func (c *Client) Remove(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.indexOf(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrMiddlewareNotFound, name)
+	}
+	c.update(i, 1)
+	return nil
+}
+
+// Middlewares returns names of the registered middleware in execution order.
+func (c *Client) Middlewares() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, len(c.middleware))
+	for i, m := range c.middleware {
+		names[i] = m.name
+	}
+	return names
+}
+
+func (c *Client) insert(target string, offset int, name string, middleware MiddlewareFunc) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.indexOf(target)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrMiddlewareNotFound, target)
+	}
+	if c.indexOf(name) >= 0 {
+		return fmt.Errorf("%w: %s", ErrDuplicateMiddleware, name)
+	}
+	c.update(i+offset, 0, namedMiddleware{name: name, fn: Named(name, middleware)})
+	return nil
+}
+
+func (c *Client) indexOf(name string) int {
+	for i, m := range c.middleware {
+		if m.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// update replaces del middleware at position i with mw and invalidates the cached chain.
+// The slice is copied on write, so it is never shared with a composed chain.
+// It must be called with c.mu held.
+func (c *Client) update(i, del int, mw ...namedMiddleware) {
+	updated := make([]namedMiddleware, 0, len(c.middleware)-del+len(mw))
+	updated = append(updated, c.middleware[:i]...)
+	updated = append(updated, mw...)
+	updated = append(updated, c.middleware[i+del:]...)
+	c.middleware = updated
+	c.chain.Store(&middlewareChain{})
+}
+
+// handler returns the cached middleware chain composing it when
+// middleware or http.Client were changed
+func (c *Client) handler() Responder {
+	if chain, ok := c.chain.Load().(*middlewareChain); ok && chain.handler != nil && chain.client == c.Client {
+		return chain.handler
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if chain, ok := c.chain.Load().(*middlewareChain); ok && chain.handler != nil && chain.client == c.Client {
+		return chain.handler
+	}
+	mw := make([]MiddlewareFunc, len(c.middleware))
+	for i, m := range c.middleware {
+		mw[i] = m.fn
+	}
+	chain := &middlewareChain{
+		client:  c.Client,
+		handler: applyMiddleware(c.Client, c.defaultResponder, mw...),
+	}
+	c.chain.Store(chain)
+	return chain.handler
+}
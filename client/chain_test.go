@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestNamedMiddleware(t *testing.T) {
+	var calls []string
+	record := func(name string) MiddlewareFunc {
+		return func(c *http.Client, next Responder) Responder {
+			return func(request *http.Request) (*http.Response, error) {
+				calls = append(calls, name)
+				return next(request)
+			}
+		}
+	}
+	mock := createMock(url, wantStatusCode, wantBody)
+	richClient := NewClient(mock)
+	richClient.Use(record("anonymous"))
+	for _, name := range []string{"retry", "logging"} {
+		if err := richClient.UseNamed(name, record(name)); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+	}
+	assertChain := func(t testing.TB, want ...string) {
+		t.Helper()
+		calls = nil
+		response, err := richClient.Client.Get(url)
+		assertResponse(t, response, err)
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("got calls %v, want %v", calls, want)
+		}
+	}
+
+	t.Run("Should list middleware in execution order", func(t *testing.T) {
+		want := []string{"middleware-1", "retry", "logging"}
+		if got := richClient.Middlewares(); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		assertChain(t, "anonymous", "retry", "logging")
+	})
+	t.Run("Should insert middleware before and after target", func(t *testing.T) {
+		if err := richClient.Before("retry", "auth", record("auth")); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if err := richClient.After("logging", "tracing", record("tracing")); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		want := []string{"middleware-1", "auth", "retry", "logging", "tracing"}
+		if got := richClient.Middlewares(); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		assertChain(t, "anonymous", "auth", "retry", "logging", "tracing")
+	})
+	t.Run("Should replace and remove middleware", func(t *testing.T) {
+		if err := richClient.Replace("retry", record("retry-v2")); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if err := richClient.Remove("middleware-1"); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		assertChain(t, "auth", "retry-v2", "logging", "tracing")
+	})
+	t.Run("Should fail on unknown or duplicate names", func(t *testing.T) {
+		if err := richClient.Remove("unknown"); !errors.Is(err, ErrMiddlewareNotFound) {
+			t.Errorf("expected ErrMiddlewareNotFound but got %v", err)
+		}
+		if err := richClient.Before("unknown", "x", record("x")); !errors.Is(err, ErrMiddlewareNotFound) {
+			t.Errorf("expected ErrMiddlewareNotFound but got %v", err)
+		}
+		if err := richClient.UseNamed("auth", record("auth")); !errors.Is(err, ErrDuplicateMiddleware) {
+			t.Errorf("expected ErrDuplicateMiddleware but got %v", err)
+		}
+		if err := richClient.After("retry", "auth", record("auth")); !errors.Is(err, ErrDuplicateMiddleware) {
+			t.Errorf("expected ErrDuplicateMiddleware but got %v", err)
+		}
+	})
+	t.Run("Should find wrapped Named middleware by its name", func(t *testing.T) {
+		wrap := func(middleware MiddlewareFunc) MiddlewareFunc {
+			return func(c *http.Client, next Responder) Responder {
+				return middleware(c, next)
+			}
+		}
+		c := NewClient(createMock(url, wantStatusCode, wantBody))
+		c.Use(record("anonymous"))
+		if err := c.UseNamed("retry", wrap(Named("retry", record("retry")))); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if err := c.Before("retry", "auth", record("auth")); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		want := []string{"middleware-1", "auth", "retry"}
+		if got := c.Middlewares(); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		calls = nil
+		req, err := NewHTTPRequest(context.Background(), http.MethodGet, url, nil, WithoutMiddleware("retry"))
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		response, err := c.Client.Do(req)
+		assertResponse(t, response, err)
+		if want := []string{"anonymous", "auth"}; !reflect.DeepEqual(calls, want) {
+			t.Errorf("got calls %v, want %v", calls, want)
+		}
+	})
+}
@@ -0,0 +1,269 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	neturl "net/url"
+	"strings"
+	"sync"
+)
+
+// Content types supported by built-in codecs.
+const (
+	ContentTypeJSON = jsonContentType
+	ContentTypeXML  = "application/xml"
+	ContentTypeForm = "application/x-www-form-urlencoded"
+	ContentTypeText = "text/plain"
+	ContentTypeRaw  = "application/octet-stream"
+)
+
+var (
+	// ErrUnsupportedContentType is returned when there is no codec registered for a content type.
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+	// ErrUnsupportedValue is returned when a codec cannot encode or decode a value of given type.
+	ErrUnsupportedValue = errors.New("unsupported value type")
+
+	// DefaultCodecs is a codec registry used when client or request does not provide one.
+	DefaultCodecs = NewCodecs()
+)
+
+// Codec encodes request bodies and decodes response bodies of a single content type.
+type Codec interface {
+	// ContentType returns the media type handled by the codec, e.g. "application/json"
+	ContentType() string
+	// Encode serializes v into the request body
+	Encode(v interface{}) ([]byte, error)
+	// Decode deserializes the response body from r into v
+	Decode(r io.Reader, v interface{}) error
+}
+
+// Codecs is a registry of Codec mapped by content type. It is safe for concurrent use.
+type Codecs struct {
+	mu     sync.RWMutex
+	codecs map[string]Codec
+}
+
+type codecsKey struct{}
+
+// NewCodecs creates a registry with the built-in JSON, XML, form-urlencoded, text and raw codecs
+// and the provided additional codecs, which override built-in ones with the same content type.
+func NewCodecs(codecs ...Codec) *Codecs {
+	r := &Codecs{codecs: map[string]Codec{}}
+	r.Register(JSONCodec{}, XMLCodec{}, FormCodec{}, TextCodec{}, RawCodec{})
+	r.Register(codecs...)
+	return r
+}
+
+// Register adds codecs to the registry replacing codecs with the same content type.
+func (r *Codecs) Register(codecs ...Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range codecs {
+		r.codecs[strings.ToLower(c.ContentType())] = c
+	}
+}
+
+// Lookup returns a codec for the content type (the header value may contain parameters, e.g. charset).
+// Structured syntax suffixes like "application/problem+json" fall back to the codec of the suffix.
+func (r *Codecs) Lookup(contentType string) (Codec, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %v", ErrUnsupportedContentType, contentType, err)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if c, ok := r.codecs[mediaType]; ok {
+		return c, nil
+	}
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		switch mediaType[i+1:] {
+		case "json":
+			if c, ok := r.codecs[ContentTypeJSON]; ok {
+				return c, nil
+			}
+		case "xml":
+			if c, ok := r.codecs[ContentTypeXML]; ok {
+				return c, nil
+			}
+		}
+	}
+	if mediaType == "text/xml" {
+		if c, ok := r.codecs[ContentTypeXML]; ok {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnsupportedContentType, contentType)
+}
+
+// WithCodecs returns a copy of ctx carrying the codec registry for a single request.
+func WithCodecs(ctx context.Context, codecs *Codecs) context.Context {
+	return context.WithValue(ctx, codecsKey{}, codecs)
+}
+
+// CodecsFromContext returns codec registry stored in ctx or DefaultCodecs.
+func CodecsFromContext(ctx context.Context) *Codecs {
+	if ctx != nil {
+		if codecs, ok := ctx.Value(codecsKey{}).(*Codecs); ok && codecs != nil {
+			return codecs
+		}
+	}
+	return DefaultCodecs
+}
+
+// JSONCodec is a codec for "application/json" content type.
+type JSONCodec struct{}
+
+// ContentType implements Codec interface.
+func (JSONCodec) ContentType() string { return ContentTypeJSON }
+
+// Encode implements Codec interface.
+func (JSONCodec) Encode(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Decode implements Codec interface. The body is decoded as a stream.
+func (JSONCodec) Decode(r io.Reader, v interface{}) error {
+	err := json.NewDecoder(r).Decode(v)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// XMLCodec is a codec for "application/xml" content type.
+type XMLCodec struct{}
+
+// ContentType implements Codec interface.
+func (XMLCodec) ContentType() string { return ContentTypeXML }
+
+// Encode implements Codec interface.
+func (XMLCodec) Encode(v interface{}) ([]byte, error) { return xml.Marshal(v) }
+
+// Decode implements Codec interface.
+func (XMLCodec) Decode(r io.Reader, v interface{}) error { return xml.NewDecoder(r).Decode(v) }
+
+// FormCodec is a codec for "application/x-www-form-urlencoded" content type.
+// It supports neturl.Values, map[string]string and map[string][]string values.
+type FormCodec struct{}
+
+// ContentType implements Codec interface.
+func (FormCodec) ContentType() string { return ContentTypeForm }
+
+// Encode implements Codec interface.
+func (FormCodec) Encode(v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case neturl.Values:
+		return []byte(val.Encode()), nil
+	case map[string][]string:
+		return []byte(neturl.Values(val).Encode()), nil
+	case map[string]string:
+		values := neturl.Values{}
+		for k, s := range val {
+			values.Set(k, s)
+		}
+		return []byte(values.Encode()), nil
+	default:
+		return nil, fmt.Errorf("%w %T for %s", ErrUnsupportedValue, v, ContentTypeForm)
+	}
+}
+
+// Decode implements Codec interface.
+func (FormCodec) Decode(r io.Reader, v interface{}) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	values, err := neturl.ParseQuery(string(b))
+	if err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case *neturl.Values:
+		*val = values
+	case *map[string][]string:
+		*val = values
+	case *map[string]string:
+		m := make(map[string]string, len(values))
+		for k := range values {
+			m[k] = values.Get(k)
+		}
+		*val = m
+	default:
+		return fmt.Errorf("%w %T for %s", ErrUnsupportedValue, v, ContentTypeForm)
+	}
+	return nil
+}
+
+// TextCodec is a codec for "text/plain" content type.
+// It supports string, []byte and fmt.Stringer values.
+type TextCodec struct{}
+
+// ContentType implements Codec interface.
+func (TextCodec) ContentType() string { return ContentTypeText }
+
+// Encode implements Codec interface.
+func (TextCodec) Encode(v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case string:
+		return []byte(val), nil
+	case []byte:
+		return val, nil
+	case fmt.Stringer:
+		return []byte(val.String()), nil
+	default:
+		return nil, fmt.Errorf("%w %T for %s", ErrUnsupportedValue, v, ContentTypeText)
+	}
+}
+
+// Decode implements Codec interface.
+func (TextCodec) Decode(r io.Reader, v interface{}) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case *string:
+		*val = string(b)
+	case *[]byte:
+		*val = b
+	default:
+		return fmt.Errorf("%w %T for %s", ErrUnsupportedValue, v, ContentTypeText)
+	}
+	return nil
+}
+
+// RawCodec is a codec for "application/octet-stream" content type.
+// It supports []byte values and decodes into *[]byte or io.Writer.
+type RawCodec struct{}
+
+// ContentType implements Codec interface.
+func (RawCodec) ContentType() string { return ContentTypeRaw }
+
+// Encode implements Codec interface.
+func (RawCodec) Encode(v interface{}) ([]byte, error) {
+	if b, ok := v.([]byte); ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("%w %T for %s", ErrUnsupportedValue, v, ContentTypeRaw)
+}
+
+// Decode implements Codec interface.
+func (RawCodec) Decode(r io.Reader, v interface{}) error {
+	switch val := v.(type) {
+	case *[]byte:
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		*val = b
+		return nil
+	case io.Writer:
+		_, err := io.Copy(val, r)
+		return err
+	default:
+		return fmt.Errorf("%w %T for %s", ErrUnsupportedValue, v, ContentTypeRaw)
+	}
+}
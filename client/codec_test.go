@@ -0,0 +1,149 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"testing"
+)
+
+type upperCodec struct{}
+
+func (upperCodec) ContentType() string { return "application/x-upper" }
+
+func (upperCodec) Encode(v interface{}) ([]byte, error) {
+	return []byte(strings.ToUpper(v.(string))), nil
+}
+
+func (upperCodec) Decode(r io.Reader, v interface{}) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	*(v.(*string)) = strings.ToUpper(string(b))
+	return nil
+}
+
+type xmlModel struct {
+	XMLName xml.Name `xml:"item"`
+	ID      int      `xml:"id"`
+}
+
+func TestCodecs(t *testing.T) {
+	codecURL := "https://www.example.com/codec"
+	newMock := func(contentType, body string) (*MockTransport, *http.Request) {
+		var sent http.Request
+		mock := NewMockTransport(true)
+		responder := func(request *http.Request) (*http.Response, error) {
+			sent = *request
+			header := make(http.Header)
+			header.Set("Content-Type", contentType)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
+				Header:     header,
+			}, nil
+		}
+		mock.RegisterResponder(http.MethodGet, codecURL, responder)
+		mock.RegisterResponder(http.MethodPost, codecURL, responder)
+		return mock, &sent
+	}
+	ctx := context.Background()
+
+	t.Run("Should lookup codec by content type", func(t *testing.T) {
+		codecs := NewCodecs()
+		tests := map[string]string{
+			"application/json; charset=utf-8": ContentTypeJSON,
+			"application/problem+json":        ContentTypeJSON,
+			"text/xml":                        ContentTypeXML,
+			"application/atom+xml":            ContentTypeXML,
+			"TEXT/PLAIN":                      ContentTypeText,
+		}
+		for contentType, want := range tests {
+			c, err := codecs.Lookup(contentType)
+			if err != nil {
+				t.Fatalf("did not expect an error but got one %v", err)
+			}
+			if c.ContentType() != want {
+				t.Errorf("%s: got %s, want %s", contentType, c.ContentType(), want)
+			}
+		}
+		if _, err := codecs.Lookup("application/msgpack"); !errors.Is(err, ErrUnsupportedContentType) {
+			t.Errorf("expected ErrUnsupportedContentType but got %v", err)
+		}
+	})
+	t.Run("Should decode XML response", func(t *testing.T) {
+		mock, _ := newMock("application/xml", `<item><id>5</id></item>`)
+		resp, err := GetAs[xmlModel](ctx, NewClient(mock), codecURL)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if resp.Value.ID != 5 {
+			t.Errorf("got %d, want %d", resp.Value.ID, 5)
+		}
+	})
+	t.Run("Should decode text response", func(t *testing.T) {
+		mock, _ := newMock("text/plain; charset=utf-8", `hello`)
+		var response string
+		if err := NewClient(mock).Get(codecURL, &response); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if response != "hello" {
+			t.Errorf("got %q, want %q", response, "hello")
+		}
+	})
+	t.Run("Should encode form request", func(t *testing.T) {
+		mock, sent := newMock("application/x-www-form-urlencoded", `a=1&b=2`)
+		body := neturl.Values{"q": []string{"x y"}}
+		resp, err := PostAs[neturl.Values, neturl.Values](ctx, NewClient(mock), codecURL, body,
+			WithContentType(ContentTypeForm), WithAccept(ContentTypeForm))
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if resp.Value.Get("b") != "2" {
+			t.Errorf("got %q, want %q", resp.Value.Get("b"), "2")
+		}
+		if sent.Header.Get("Content-Type") != ContentTypeForm || sent.Header.Get("Accept") != ContentTypeForm {
+			t.Errorf("bad headers: %v", sent.Header)
+		}
+		b, _ := io.ReadAll(sent.Body)
+		if string(b) != "q=x+y" {
+			t.Errorf("got body %q, want %q", b, "q=x+y")
+		}
+	})
+	t.Run("Should use user registered codec", func(t *testing.T) {
+		mock, sent := newMock("application/x-upper", `done`)
+		c := NewClient(mock)
+		c.RegisterCodec(upperCodec{})
+		resp, err := PostAs[string, string](ctx, c, codecURL, "hello", WithContentType("application/x-upper"))
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if resp.Value != "DONE" {
+			t.Errorf("got %q, want %q", resp.Value, "DONE")
+		}
+		b, _ := io.ReadAll(sent.Body)
+		if string(b) != "HELLO" {
+			t.Errorf("got body %q, want %q", b, "HELLO")
+		}
+		if _, err = DefaultCodecs.Lookup("application/x-upper"); err == nil {
+			t.Errorf("client codec should not be registered globally")
+		}
+	})
+	t.Run("Should fail on unsupported content type", func(t *testing.T) {
+		mock, _ := newMock("application/msgpack", `...`)
+		_, err := GetAs[typedModel](ctx, NewClient(mock), codecURL)
+		if !errors.Is(err, ErrUnsupportedContentType) {
+			t.Errorf("expected ErrUnsupportedContentType but got %v", err)
+		}
+		_, err = NewRequest(ctx, http.MethodPost, codecURL, typedModel{}, WithContentType("application/msgpack"))
+		if !errors.Is(err, ErrUnsupportedContentType) {
+			t.Errorf("expected ErrUnsupportedContentType but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,267 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"time"
+)
+
+// PoolingMode defines if the transport created by New keeps connections alive for reuse.
+type PoolingMode int
+
+// These constants are pooling modes of the transport created by New.
+const (
+	// PoolingDisabled disables idle connections and keepalives (see DefaultTransport)
+	PoolingDisabled PoolingMode = iota
+	// PoolingEnabled keeps idle connections for reuse (see DefaultPooledTransport).
+	// Only use it for clients that will be re-used for the same host(s).
+	PoolingEnabled
+)
+
+// ErrInvalidOption is returned by New when options are invalid or conflicting.
+var ErrInvalidOption = errors.New("invalid client option")
+
+// Option configures Client created by New.
+type Option func(*clientConfig)
+
+type clientConfig struct {
+	transport             http.RoundTripper
+	pooling               *PoolingMode
+	dialTimeout           time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	timeout               time.Duration
+	proxy                 func(*http.Request) (*neturl.URL, error)
+	proxySet              bool
+	tlsConfig             *tls.Config
+	header                http.Header
+	baseURL               string
+	middleware            []MiddlewareFunc
+	codecs                *Codecs
+	extraCodecs           []Codec
+	statusPolicy          StatusPolicy
+	maxBodySize           int64
+}
+
+// hasTransportOptions reports if any option configuring http.Transport is set
+func (c *clientConfig) hasTransportOptions() bool {
+	return c.dialTimeout != 0 || c.tlsHandshakeTimeout != 0 || c.responseHeaderTimeout != 0 ||
+		c.proxySet || c.tlsConfig != nil
+}
+
+// WithTransport sets the transport used by the client. Options configuring
+// the transport (timeouts, proxy, TLS) can be combined only with *http.Transport,
+// which is cloned before modification.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *clientConfig) {
+		c.transport = transport
+	}
+}
+
+// WithPooling sets the pooling mode of the transport created by New. The default is PoolingDisabled.
+func WithPooling(mode PoolingMode) Option {
+	return func(c *clientConfig) {
+		c.pooling = &mode
+	}
+}
+
+// WithDialTimeout sets the maximum amount of time a dial will wait for a connect to complete.
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *clientConfig) {
+		c.dialTimeout = timeout
+	}
+}
+
+// WithTLSHandshakeTimeout sets the maximum amount of time waiting to wait for a TLS handshake.
+func WithTLSHandshakeTimeout(timeout time.Duration) Option {
+	return func(c *clientConfig) {
+		c.tlsHandshakeTimeout = timeout
+	}
+}
+
+// WithResponseHeaderTimeout sets the amount of time to wait for a server's response headers
+// after fully writing the request.
+func WithResponseHeaderTimeout(timeout time.Duration) Option {
+	return func(c *clientConfig) {
+		c.responseHeaderTimeout = timeout
+	}
+}
+
+// WithDefaultTimeout sets the overall time limit for requests made by the client (see Client.Timeout).
+// It can be overridden for a single request by WithTimeout.
+func WithDefaultTimeout(timeout time.Duration) Option {
+	return func(c *clientConfig) {
+		c.timeout = timeout
+	}
+}
+
+// WithProxy sets the proxy function of the transport. Nil disables proxy.
+// The default is http.ProxyFromEnvironment.
+func WithProxy(proxy func(*http.Request) (*neturl.URL, error)) Option {
+	return func(c *clientConfig) {
+		c.proxy = proxy
+		c.proxySet = true
+	}
+}
+
+// WithTLSConfig sets the TLS configuration of the transport.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *clientConfig) {
+		c.tlsConfig = config
+	}
+}
+
+// WithDefaultHeaders sets headers added to every request which does not have them.
+func WithDefaultHeaders(header http.Header) Option {
+	return func(c *clientConfig) {
+		if c.header == nil {
+			c.header = http.Header{}
+		}
+		for k, v := range header {
+			c.header[http.CanonicalHeaderKey(k)] = append([]string{}, v...)
+		}
+	}
+}
+
+// WithDefaultBaseURL sets Client.BaseURL.
+func WithDefaultBaseURL(baseURL string) Option {
+	return func(c *clientConfig) {
+		c.baseURL = baseURL
+	}
+}
+
+// WithDefaultMiddleware adds middleware to the client chain (see Client.Use).
+func WithDefaultMiddleware(middleware ...MiddlewareFunc) Option {
+	return func(c *clientConfig) {
+		c.middleware = append(c.middleware, middleware...)
+	}
+}
+
+// WithCodecRegistry sets Client.Codecs.
+func WithCodecRegistry(codecs *Codecs) Option {
+	return func(c *clientConfig) {
+		c.codecs = codecs
+	}
+}
+
+// WithCodec registers additional codecs in the client codec registry.
+func WithCodec(codecs ...Codec) Option {
+	return func(c *clientConfig) {
+		c.extraCodecs = append(c.extraCodecs, codecs...)
+	}
+}
+
+// WithDefaultStatusPolicy sets Client.StatusPolicy.
+func WithDefaultStatusPolicy(policy StatusPolicy) Option {
+	return func(c *clientConfig) {
+		c.statusPolicy = policy
+	}
+}
+
+// WithDefaultMaxBodySize sets Client.MaxBodySize.
+func WithDefaultMaxBodySize(size int64) Option {
+	return func(c *clientConfig) {
+		c.maxBodySize = size
+	}
+}
+
+// validate checks the option combination
+func (c *clientConfig) validate() error {
+	if c.dialTimeout < 0 || c.tlsHandshakeTimeout < 0 || c.responseHeaderTimeout < 0 || c.timeout < 0 {
+		return fmt.Errorf("%w: timeout cannot be negative", ErrInvalidOption)
+	}
+	if c.maxBodySize < 0 {
+		return fmt.Errorf("%w: maximum body size cannot be negative", ErrInvalidOption)
+	}
+	if c.transport != nil {
+		if c.pooling != nil {
+			return fmt.Errorf("%w: pooling mode cannot be combined with custom transport", ErrInvalidOption)
+		}
+		if _, ok := c.transport.(*http.Transport); !ok && c.hasTransportOptions() {
+			return fmt.Errorf("%w: transport options require *http.Transport, got %T", ErrInvalidOption, c.transport)
+		}
+	}
+	if c.baseURL != "" {
+		u, err := neturl.Parse(c.baseURL)
+		if err != nil {
+			return fmt.Errorf("%w: base URL: %v", ErrInvalidOption, err)
+		}
+		if !u.IsAbs() || u.Host == "" {
+			return fmt.Errorf("%w: base URL %q is not absolute", ErrInvalidOption, c.baseURL)
+		}
+	}
+	return nil
+}
+
+// buildTransport creates or clones transport and applies transport options
+func (c *clientConfig) buildTransport() http.RoundTripper {
+	if c.transport != nil && !c.hasTransportOptions() {
+		return c.transport
+	}
+	var t *http.Transport
+	switch {
+	case c.transport != nil:
+		t = c.transport.(*http.Transport).Clone()
+	case c.pooling != nil && *c.pooling == PoolingEnabled:
+		t = DefaultPooledTransport()
+	default:
+		t = DefaultTransport()
+	}
+	if c.dialTimeout > 0 {
+		t.DialContext = (&net.Dialer{
+			Timeout:   c.dialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if c.tlsHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = c.tlsHandshakeTimeout
+	}
+	if c.responseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = c.responseHeaderTimeout
+	}
+	if c.proxySet {
+		t.Proxy = c.proxy
+	}
+	if c.tlsConfig != nil {
+		t.TLSClientConfig = c.tlsConfig
+	}
+	return t
+}
+
+// New creates Client configured with options. Without options it is equal to DefaultClient.
+//
+//	c, err := client.New(
+//		client.WithPooling(client.PoolingEnabled),
+//		client.WithDefaultBaseURL("https://api.example.com/v1"),
+//		client.WithDefaultTimeout(30*time.Second),
+//		client.WithDefaultMiddleware(middleware.Retry()),
+//	)
+func New(opts ...Option) (*Client, error) {
+	cfg := &clientConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	c := NewClient(cfg.buildTransport())
+	c.Timeout = cfg.timeout
+	c.Header = cfg.header
+	c.BaseURL = cfg.baseURL
+	c.StatusPolicy = cfg.statusPolicy
+	c.MaxBodySize = cfg.maxBodySize
+	if cfg.codecs != nil {
+		c.Codecs = cfg.codecs
+	}
+	if len(cfg.extraCodecs) > 0 {
+		c.RegisterCodec(cfg.extraCodecs...)
+	}
+	if len(cfg.middleware) > 0 {
+		c.Use(cfg.middleware...)
+	}
+	return c, nil
+}
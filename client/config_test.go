@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("Should configure transport", func(t *testing.T) {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		c, err := New(
+			WithPooling(PoolingEnabled),
+			WithTLSHandshakeTimeout(time.Second),
+			WithResponseHeaderTimeout(2*time.Second),
+			WithDialTimeout(3*time.Second),
+			WithProxy(nil),
+			WithTLSConfig(tlsConfig),
+			WithDefaultTimeout(time.Minute),
+		)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if c.Timeout != time.Minute || c.Client.Timeout != 0 {
+			t.Errorf("got timeout %v, want %v", c.Timeout, time.Minute)
+		}
+		cfg := &clientConfig{}
+		for _, opt := range []Option{WithPooling(PoolingEnabled), WithTLSConfig(tlsConfig),
+			WithTLSHandshakeTimeout(time.Second), WithResponseHeaderTimeout(2 * time.Second), WithProxy(nil)} {
+			opt(cfg)
+		}
+		transport := cfg.buildTransport().(*http.Transport)
+		if transport.DisableKeepAlives || transport.TLSClientConfig != tlsConfig || transport.Proxy != nil ||
+			transport.TLSHandshakeTimeout != time.Second || transport.ResponseHeaderTimeout != 2*time.Second {
+			t.Errorf("transport is not configured %+v", transport)
+		}
+	})
+	t.Run("Should configure client", func(t *testing.T) {
+		var got http.Header
+		mock := createMock("https://www.example.com/api/items", wantStatusCode, `{"id":3}`)
+		c, err := New(
+			WithTransport(mock),
+			WithDefaultBaseURL("https://www.example.com/api"),
+			WithDefaultHeaders(http.Header{"x-api-key": {"key"}}),
+			WithDefaultStatusPolicy(LenientStatusPolicy),
+			WithDefaultMaxBodySize(1024),
+			WithCodec(upperCodec{}),
+			WithDefaultMiddleware(func(c *http.Client, next Responder) Responder {
+				return func(request *http.Request) (*http.Response, error) {
+					got = request.Header
+					return next(request)
+				}
+			}),
+		)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		resp, err := GetAs[typedModel](context.Background(), c, "/items")
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if resp.Value.ID != 3 {
+			t.Errorf("got %d, want %d", resp.Value.ID, 3)
+		}
+		if got.Get("X-Api-Key") != "key" {
+			t.Errorf("default header is not set: %v", got)
+		}
+		if c.StatusPolicy == nil || c.MaxBodySize != 1024 {
+			t.Errorf("client is not configured")
+		}
+		if _, err = c.Codecs.Lookup("application/x-upper"); err != nil {
+			t.Errorf("codec is not registered: %v", err)
+		}
+	})
+	t.Run("Should not override request headers", func(t *testing.T) {
+		c, _ := New(WithDefaultHeaders(http.Header{"X-Api-Key": {"key"}}))
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("X-Api-Key", "other")
+		if got := c.withDefaultHeaders(req); got.Header.Get("X-Api-Key") != "other" {
+			t.Errorf("got %q, want %q", got.Header.Get("X-Api-Key"), "other")
+		}
+		req.Header.Del("X-Api-Key")
+		if got := c.withDefaultHeaders(req); got.Header.Get("X-Api-Key") != "key" || req.Header.Get("X-Api-Key") != "" {
+			t.Errorf("default header should be added to the request copy")
+		}
+	})
+	t.Run("Should fail on invalid options", func(t *testing.T) {
+		tests := map[string][]Option{
+			"negative timeout":           {WithDefaultTimeout(-time.Second)},
+			"pooling with transport":     {WithTransport(NewMockTransport(true)), WithPooling(PoolingEnabled)},
+			"TLS config with mock":       {WithTransport(NewMockTransport(true)), WithTLSConfig(&tls.Config{})},
+			"relative base URL":          {WithDefaultBaseURL("/api")},
+			"negative maximum body size": {WithDefaultMaxBodySize(-1)},
+		}
+		for name, opts := range tests {
+			if _, err := New(opts...); !errors.Is(err, ErrInvalidOption) {
+				t.Errorf("%s: expected ErrInvalidOption but got %v", name, err)
+			}
+		}
+	})
+}
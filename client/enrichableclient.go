@@ -2,10 +2,11 @@ package client
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -20,8 +21,31 @@ type MiddlewareFunc func(*http.Client, Responder) Responder
 // Client is a wrapper on the top of http.Client allowing add rich functions
 type Client struct {
 	defaultResponder Responder
-	middleware       []MiddlewareFunc
-	Client           *http.Client
+	// mu guards middleware modifications
+	mu         sync.Mutex
+	middleware []namedMiddleware
+	// seq is used to generate names of anonymous middleware
+	seq int
+	// chain is the cached composition of middleware (*middlewareChain)
+	chain  atomic.Value
+	Client *http.Client
+	// StatusPolicy decides which response status codes are successful.
+	// If StatusPolicy is nil, DefaultStatusPolicy is used.
+	StatusPolicy StatusPolicy
+	// Codecs is a registry of codecs used to encode request bodies and decode response bodies.
+	// If Codecs is nil, DefaultCodecs is used.
+	Codecs *Codecs
+	// Header contains headers added to every request which does not have them.
+	Header http.Header
+	// BaseURL is the URL relative request URLs are resolved against,
+	// e.g. "https://api.example.com/v1" for "/users/{id}".
+	BaseURL string
+	// MaxBodySize is the maximum size of the response body in bytes decoded by ReadResponse,
+	// typed helpers and Stream. Zero means unlimited.
+	MaxBodySize int64
+	// Timeout is the default time limit of a request including all middleware and reading
+	// of the response body. It is replaced by WithTimeout for a single request. Zero means no timeout.
+	Timeout time.Duration
 }
 
 // NewClient creates http.Client with provided transport
@@ -30,7 +54,8 @@ func NewClient(transport http.RoundTripper) *Client {
 		transport = http.DefaultClient.Transport
 	}
 	client := &Client{
-		defaultResponder: transport.RoundTrip,
+		defaultResponder: withRequest(transport.RoundTrip),
+		Codecs:           NewCodecs(),
 	}
 	client.Client = NewHTTPClient(client)
 
@@ -39,41 +64,60 @@ func NewClient(transport http.RoundTripper) *Client {
 
 // DefaultClient returns a new Client with similar default values to
 // http.Client, but with a non-shared Transport, idle connections disabled, and
-// keepalives disabled.
+// keepalives disabled. It is a shortcut for New with PoolingDisabled mode;
+// use New to configure the client.
 func DefaultClient() *Client {
-	return NewClient(DefaultTransport())
+	c, _ := New(WithPooling(PoolingDisabled))
+	return c
 }
 
 // DefaultPooledClient returns a new Client with similar default values to
 // http.Client, but with a shared Transport. Do not use this function for
 // transient clients as it can leak file descriptors over time. Only use this
-// for clients that will be re-used for the same host(s).
+// for clients that will be re-used for the same host(s). It is a shortcut for
+// New with PoolingEnabled mode; use New to configure the client.
 func DefaultPooledClient() *Client {
-	return NewClient(DefaultPooledTransport())
-}
-
-// Use adds middleware to the chain which is run on processing request.
-func (c *Client) Use(middleware ...MiddlewareFunc) {
-	c.middleware = append(c.middleware, middleware...)
+	c, _ := New(WithPooling(PoolingEnabled))
+	return c
 }
 
 // Get is a convenience helper for doing simple GET requests.
 func (c *Client) Get(url string, response interface{}) error {
-	resp, err := c.Client.Get(url)
-	if err != nil {
-		return err
-	}
-
-	return ReadResponse(resp, &response)
+	return c.sendRestRequest(context.Background(), http.MethodGet, url, nil, response)
 }
 
 // Get is a shortcut for doing a GET request without making a new client.
 func Get(url string, response interface{}) error {
-	return defaultClient.Get(url, &response)
+	return defaultClient.Get(url, response)
+}
+
+// RegisterCodec adds codecs to the client codec registry.
+func (c *Client) RegisterCodec(codecs ...Codec) {
+	if c.Codecs == nil {
+		c.Codecs = NewCodecs()
+	}
+	c.Codecs.Register(codecs...)
+}
+
+// withClientContext adds the client settings to ctx unless ctx already has them
+func (c *Client) withClientContext(ctx context.Context) context.Context {
+	if c.StatusPolicy != nil && StatusPolicyFromContext(ctx) == nil {
+		ctx = WithStatusPolicy(ctx, c.StatusPolicy)
+	}
+	if c.Codecs != nil && ctx.Value(codecsKey{}) == nil {
+		ctx = WithCodecs(ctx, c.Codecs)
+	}
+	if c.BaseURL != "" && baseURLFromContext(ctx) == "" {
+		ctx = context.WithValue(ctx, baseURLKey{}, c.BaseURL)
+	}
+	if _, ok := MaxBodySizeFromContext(ctx); c.MaxBodySize > 0 && !ok {
+		ctx = WithMaxBodySize(ctx, c.MaxBodySize)
+	}
+	return ctx
 }
 
 func (c *Client) sendRestRequest(ctx context.Context, method, url string, body interface{}, response interface{}) error {
-	req, err := NewHTTPRequest(ctx, method, url, body)
+	req, err := NewHTTPRequest(c.withClientContext(ctx), method, url, body)
 	if err != nil {
 		return err
 	}
@@ -82,73 +126,144 @@ func (c *Client) sendRestRequest(ctx context.Context, method, url string, body i
 		return err
 	}
 
-	return ReadResponse(resp, &response)
+	return ReadResponse(resp, response)
 }
 
 // PostWithContext is a convenience method for doing simple POST requests.
 func (c *Client) PostWithContext(ctx context.Context, url string, body interface{}, response interface{}) error {
-	return c.sendRestRequest(ctx, "POST", url, body, &response)
+	return c.sendRestRequest(ctx, "POST", url, body, response)
 }
 
 // Post is a convenience method for doing simple POST requests.
 func (c *Client) Post(url string, body interface{}, response interface{}) error {
-	return c.sendRestRequest(context.Background(), "POST", url, body, &response)
+	return c.sendRestRequest(context.Background(), "POST", url, body, response)
 }
 
 // PostWithContext is a shortcut for doing a POST request without making a new client.
 func PostWithContext(ctx context.Context, url string, body interface{}, response interface{}) error {
-	return defaultClient.PostWithContext(ctx, url, body, &response)
+	return defaultClient.PostWithContext(ctx, url, body, response)
 }
 
 // Post is a shortcut for doing a POST request without making a new client.
 func Post(url string, body interface{}, response interface{}) error {
-	return defaultClient.Post(url, body, &response)
+	return defaultClient.Post(url, body, response)
 }
 
 // PutWithContext is a convenience method for doing simple PUT requests.
 func (c *Client) PutWithContext(ctx context.Context, url string, body interface{}, response interface{}) error {
-	return c.sendRestRequest(ctx, "PUT", url, body, &response)
+	return c.sendRestRequest(ctx, "PUT", url, body, response)
 }
 
 // Put is a convenience method for doing simple PUT requests.
 func (c *Client) Put(url string, body interface{}, response interface{}) error {
-	return c.sendRestRequest(context.Background(), "PUT", url, body, &response)
+	return c.sendRestRequest(context.Background(), "PUT", url, body, response)
 }
 
 // PutWithContext is a shortcut for doing a PUT request without making a new client.
 func PutWithContext(ctx context.Context, url string, body interface{}, response interface{}) error {
-	return defaultClient.PutWithContext(ctx, url, body, &response)
+	return defaultClient.PutWithContext(ctx, url, body, response)
 }
 
 // Put is a shortcut for doing a PUT request without making a new client.
 func Put(url string, body interface{}, response interface{}) error {
-	return defaultClient.Put(url, body, &response)
+	return defaultClient.Put(url, body, response)
+}
+
+// PatchWithContext is a convenience method for doing simple PATCH requests.
+func (c *Client) PatchWithContext(ctx context.Context, url string, body interface{}, response interface{}) error {
+	return c.sendRestRequest(ctx, http.MethodPatch, url, body, response)
+}
+
+// Patch is a convenience method for doing simple PATCH requests.
+func (c *Client) Patch(url string, body interface{}, response interface{}) error {
+	return c.sendRestRequest(context.Background(), http.MethodPatch, url, body, response)
+}
+
+// PatchWithContext is a shortcut for doing a PATCH request without making a new client.
+func PatchWithContext(ctx context.Context, url string, body interface{}, response interface{}) error {
+	return defaultClient.PatchWithContext(ctx, url, body, response)
+}
+
+// Patch is a shortcut for doing a PATCH request without making a new client.
+func Patch(url string, body interface{}, response interface{}) error {
+	return defaultClient.Patch(url, body, response)
 }
 
 // DeleteWithContext is a convenience method for doing simple DELETE requests.
 func (c *Client) DeleteWithContext(ctx context.Context, url string, body interface{}, response interface{}) error {
-	return c.sendRestRequest(ctx, "DELETE", url, body, &response)
+	return c.sendRestRequest(ctx, "DELETE", url, body, response)
 }
 
 // Delete is a convenience method for doing simple DELETE requests.
 func (c *Client) Delete(url string, body interface{}, response interface{}) error {
-	return c.sendRestRequest(context.Background(), "DELETE", url, body, &response)
+	return c.sendRestRequest(context.Background(), "DELETE", url, body, response)
 }
 
 // DeleteWithContext is a shortcut for doing a DELETE request without making a new client.
 func DeleteWithContext(ctx context.Context, url string, body interface{}, response interface{}) error {
-	return defaultClient.DeleteWithContext(ctx, url, body, &response)
+	return defaultClient.DeleteWithContext(ctx, url, body, response)
 }
 
 // Delete is a shortcut for doing a DELETE request without making a new client.
 func Delete(url string, body interface{}, response interface{}) error {
-	return defaultClient.Delete(url, body, &response)
+	return defaultClient.Delete(url, body, response)
 }
 
 // RoundTrip executes a single HTTP transaction, returning a Response for the provided Request
+// with the middleware chain and request options (see RequestOption) applied.
 func (c *Client) RoundTrip(req *http.Request) (*http.Response, error) {
-	h := applyMiddleware(c.Client, c.defaultResponder, c.middleware...)
-	return h(req)
+	ctx := c.withClientContext(req.Context())
+	o := callOptionsFromContext(ctx)
+	timeout := c.Timeout
+	if o != nil && o.timeout > 0 {
+		timeout = o.timeout
+	}
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	if ctx != req.Context() {
+		req = req.WithContext(ctx)
+	}
+	req = c.withDefaultHeaders(req)
+	h := c.handler()
+	if o != nil && len(o.middleware) > 0 {
+		h = applyMiddleware(c.Client, h, o.middleware...)
+	}
+	resp, err := h(req)
+	if cancel != nil {
+		if resp == nil || resp.Body == nil {
+			cancel()
+		} else {
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+		}
+	}
+	return resp, err
+}
+
+// withDefaultHeaders returns a copy of the request with Client.Header added
+// when the request does not have them
+func (c *Client) withDefaultHeaders(req *http.Request) *http.Request {
+	var header http.Header
+	for k, v := range c.Header {
+		if _, ok := req.Header[k]; ok {
+			continue
+		}
+		if header == nil {
+			header = req.Header.Clone()
+			if header == nil {
+				header = http.Header{}
+			}
+		}
+		header[k] = v
+	}
+	if header == nil {
+		return req
+	}
+	r := new(http.Request)
+	*r = *req
+	r.Header = header
+	return r
 }
 
 func applyMiddleware(c *http.Client, h Responder, middleware ...MiddlewareFunc) Responder {
@@ -158,29 +273,64 @@ func applyMiddleware(c *http.Client, h Responder, middleware ...MiddlewareFunc)
 	return h
 }
 
-// AssertStatusCode verify if response status code is successful
+// withRequest sets the originating request to the response if transport did not do it,
+// so StatusPolicy of the request is available to the response consumers.
+func withRequest(next Responder) Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		resp, err := next(req)
+		if resp != nil && resp.Request == nil {
+			resp.Request = req
+		}
+		return resp, err
+	}
+}
+
+// AssertStatusCode verify if response status code is successful according to
+// the StatusPolicy of the request (see ClassifyResponse).
+// It returns *HTTPError without consuming the response body otherwise.
 func AssertStatusCode(resp *http.Response) error {
 	if resp == nil {
 		return nil
 	}
-	if (resp.StatusCode >= http.StatusOK && resp.StatusCode <= 299) ||
-		resp.StatusCode == http.StatusNotFound ||
-		resp.StatusCode == http.StatusNotModified {
+	if ClassifyResponse(resp) != StatusClassError {
 		return nil
 	}
-	return fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	return NewHTTPError(resp)
 }
 
-// ReadResponse read JSON response and return deserialized object
+// ReadResponse read response and return deserialized object.
+// The decoder is chosen from the codec registry of the request (see WithCodecs) by the response
+// Content-Type header; responses without Content-Type are decoded as JSON.
+// JSON bodies are decoded as a stream without buffering the whole body.
+// The response body is always drained and closed. On unexpected status code
+// *HTTPError with a bounded copy of the response body is returned.
+// The response body is not deserialized when StatusPolicy classifies
+// the response as StatusClassEmptySuccess.
 func ReadResponse(resp *http.Response, response interface{}) error {
-	if err := AssertStatusCode(resp); err != nil {
-		return err
+	defer DrainBody(resp.Body)
+	switch ClassifyResponse(resp) {
+	case StatusClassError:
+		return readErrorBody(NewHTTPError(resp), resp.Body)
+	case StatusClassEmptySuccess:
+		return nil
+	}
+	return decodeBody(resp, resp.Body, response)
+}
+
+// decodeBody decodes body with codec matching the response Content-Type
+// and fails with BodyTooLargeError when body exceeds the maximum size
+func decodeBody(resp *http.Response, body io.Reader, response interface{}) error {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = jsonContentType
+	}
+	ctx := context.Background()
+	if resp.Request != nil {
+		ctx = resp.Request.Context()
 	}
-	defer resp.Body.Close()
-	bodyBytes, err := io.ReadAll(resp.Body)
+	codec, err := CodecsFromContext(ctx).Lookup(contentType)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(bodyBytes, &response)
-	return err
+	return codec.Decode(limitBody(ctx, body), response)
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"net/http"
+	"sync"
 	"testing"
 )
 
@@ -63,6 +64,95 @@ func TestMultipleMiddleware(t *testing.T) {
 	})
 }
 
+func TestMiddlewareChainCache(t *testing.T) {
+	mock := createMock(url, wantStatusCode, wantBody)
+	richClient := NewClient(mock)
+	composed := 0
+	richClient.Use(func(c *http.Client, next Responder) Responder {
+		composed++
+		return next
+	})
+	client := richClient.Client
+
+	t.Run("Should compose middleware chain once", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			response, err := client.Get(url)
+			assertResponse(t, response, err)
+		}
+		if composed != 1 {
+			t.Errorf("got %d compositions, want %d", composed, 1)
+		}
+	})
+	t.Run("Should recompose middleware chain after Use", func(t *testing.T) {
+		richClient.Use(createMiddleware(http.MethodHead, http.StatusConflict))
+		response, err := client.Head(url)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if response.StatusCode != http.StatusConflict {
+			t.Errorf("got %d, wantStatusCode %d", response.StatusCode, http.StatusConflict)
+		}
+		if composed != 2 {
+			t.Errorf("got %d compositions, want %d", composed, 2)
+		}
+	})
+	t.Run("Should allow Use concurrently with requests", func(t *testing.T) {
+		type result struct {
+			response *http.Response
+			err      error
+		}
+		results := make(chan result, 10)
+		var wg sync.WaitGroup
+		wg.Add(20)
+		for i := 0; i < 10; i++ {
+			go func() {
+				defer wg.Done()
+				response, err := client.Get(url)
+				results <- result{response, err}
+			}()
+			go func() {
+				defer wg.Done()
+				richClient.Use(createMiddleware(http.MethodPut, http.StatusConflict))
+			}()
+		}
+		wg.Wait()
+		close(results)
+		// assertResponse may stop the test, so it is called in the test goroutine only
+		for r := range results {
+			assertResponse(t, r.response, r.err)
+		}
+	})
+}
+
+func BenchmarkRoundTrip(b *testing.B) {
+	newClient := func() *Client {
+		mock := createMock(url, wantStatusCode, wantBody)
+		richClient := NewClient(mock)
+		for i := 0; i < 5; i++ {
+			richClient.Use(createMiddleware(http.MethodHead, http.StatusConflict))
+		}
+		return richClient
+	}
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+
+	b.Run("cached chain", func(b *testing.B) {
+		richClient := newClient()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _ = richClient.RoundTrip(req)
+		}
+	})
+	b.Run("chain composed per request", func(b *testing.B) {
+		richClient := newClient()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			// invalidate cached chain as Use does
+			richClient.chain.Store(&middlewareChain{})
+			_, _ = richClient.RoundTrip(req)
+		}
+	})
+}
+
 func assertResponse(t testing.TB, response *http.Response, err error) {
 	if err != nil {
 		t.Fatalf("did not expect an error but got one %v", err)
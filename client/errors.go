@@ -0,0 +1,110 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	// maxErrorBodySize limits the size of response body copied into HTTPError.
+	maxErrorBodySize = 64 * 1024
+	// maxDrainBodySize limits the size of response body consumed on closing
+	// to keep connection reusable.
+	maxDrainBodySize = 256 * 1024
+)
+
+// HTTPError is returned when the response has unexpected HTTP status code.
+type HTTPError struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// Status is the HTTP status line of the response, e.g. "500 Internal Server Error"
+	Status string
+	// Method is the HTTP method of the request
+	Method string
+	// URL is the request URL
+	URL string
+	// Header is the response header
+	Header http.Header
+	// Body is a bounded copy of the response body.
+	// It is populated only by functions consuming response body (e.g. ReadResponse).
+	Body []byte
+}
+
+// NewHTTPError creates HTTPError from the response without consuming the response body.
+func NewHTTPError(resp *http.Response) *HTTPError {
+	e := &HTTPError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+	}
+	if e.Status == "" {
+		e.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	if resp.Request != nil {
+		e.Method = resp.Request.Method
+		if resp.Request.URL != nil {
+			e.URL = resp.Request.URL.String()
+		}
+	}
+	return e
+}
+
+// Error implements error interface.
+func (e *HTTPError) Error() string {
+	if e.Method == "" && e.URL == "" {
+		return fmt.Sprintf("unexpected HTTP status %s", e.Status)
+	}
+	return fmt.Sprintf("%s %s: unexpected HTTP status %s", e.Method, e.URL, e.Status)
+}
+
+// IsNotFound reports whether err is an HTTPError with 404 status code.
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
+
+// IsClientError reports whether err is an HTTPError with 4xx status code.
+func IsClientError(err error) bool {
+	code := StatusCode(err)
+	return code >= 400 && code <= 499
+}
+
+// IsServerError reports whether err is an HTTPError with 5xx status code.
+func IsServerError(err error) bool {
+	code := StatusCode(err)
+	return code >= 500 && code <= 599
+}
+
+// StatusCode returns HTTP status code of HTTPError in err chain or 0 if there is no HTTPError.
+func StatusCode(err error) int {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode
+	}
+	return 0
+}
+
+// readErrorBody copies bounded response body into HTTPError
+func readErrorBody(err error, body io.Reader) error {
+	var httpErr *HTTPError
+	if body == nil || !errors.As(err, &httpErr) {
+		return err
+	}
+	b, readErr := io.ReadAll(io.LimitReader(body, maxErrorBodySize))
+	if readErr == nil {
+		httpErr.Body = b
+	}
+	return err
+}
+
+// DrainBody reads bounded part of the body and closes it, so the connection can be reused.
+func DrainBody(body io.ReadCloser) {
+	if body == nil {
+		return
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, maxDrainBodySize))
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type trackingBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *trackingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestHTTPError(t *testing.T) {
+	newResponse := func(statusCode int, body io.ReadCloser) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		header := make(http.Header)
+		header.Set("X-Request-Id", "42")
+		return &http.Response{
+			StatusCode: statusCode,
+			Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+			Body:       body,
+			Header:     header,
+			Request:    req,
+		}
+	}
+	t.Run("Should return HTTPError with response details", func(t *testing.T) {
+		body := &trackingBody{Reader: bytes.NewBufferString(`{"error":"boom"}`)}
+		var response map[string]interface{}
+		err := ReadResponse(newResponse(http.StatusInternalServerError, body), &response)
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) {
+			t.Fatalf("expected HTTPError but got %v", err)
+		}
+		if httpErr.StatusCode != http.StatusInternalServerError ||
+			httpErr.Method != http.MethodGet ||
+			httpErr.URL != url ||
+			httpErr.Header.Get("X-Request-Id") != "42" ||
+			string(httpErr.Body) != `{"error":"boom"}` {
+			t.Errorf("unexpected error content %+v", httpErr)
+		}
+		if !body.closed {
+			t.Errorf("response body should be closed")
+		}
+		if !IsServerError(err) || IsClientError(err) || IsNotFound(err) {
+			t.Errorf("wrong error classification for %v", err)
+		}
+	})
+	t.Run("Should bound the copy of response body", func(t *testing.T) {
+		body := &trackingBody{Reader: strings.NewReader(strings.Repeat("a", maxErrorBodySize*2))}
+		err := ReadResponse(newResponse(http.StatusBadRequest, body), nil)
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) {
+			t.Fatalf("expected HTTPError but got %v", err)
+		}
+		if len(httpErr.Body) != maxErrorBodySize {
+			t.Errorf("got body size %d, want %d", len(httpErr.Body), maxErrorBodySize)
+		}
+		if !IsClientError(err) {
+			t.Errorf("wrong error classification for %v", err)
+		}
+	})
+	t.Run("Should not consume body on AssertStatusCode", func(t *testing.T) {
+		body := &trackingBody{Reader: bytes.NewBufferString("error")}
+		err := AssertStatusCode(newResponse(http.StatusBadGateway, body))
+		if StatusCode(err) != http.StatusBadGateway {
+			t.Errorf("got %d, want %d", StatusCode(err), http.StatusBadGateway)
+		}
+		if body.closed {
+			t.Errorf("response body should not be closed")
+		}
+	})
+	t.Run("Should close body on successful response", func(t *testing.T) {
+		body := &trackingBody{Reader: bytes.NewBufferString(`{"id":1}`)}
+		var response typedModel
+		if err := ReadResponse(newResponse(http.StatusOK, body), &response); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if !body.closed {
+			t.Errorf("response body should be closed")
+		}
+	})
+	t.Run("Should be wrapped", func(t *testing.T) {
+		err := fmt.Errorf("wrapped: %w", &HTTPError{StatusCode: http.StatusNotFound})
+		if !IsNotFound(err) {
+			t.Errorf("wrapped HTTPError should be found")
+		}
+		if IsNotFound(errors.New("other")) {
+			t.Errorf("other error should not be HTTPError")
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// RequestOption configures a single request created by NewRequest, NewHTTPRequest
+// or typed helpers like Do. Options affecting the middleware chain are carried
+// through the request context (see WithRequestOptions).
+type RequestOption func(*requestOptions)
+
+type requestOptions struct {
+	contentType string
+	accept      string
+	baseURL     string
+	pathParams  map[string]string
+	query       []interface{}
+	call        callOptions
+	values      []contextValue
+}
+
+// callOptions are request options carried through the request context
+type callOptions struct {
+	middleware []MiddlewareFunc
+	disabled   map[string]bool
+	timeout    time.Duration
+}
+
+type contextValue struct {
+	key, value interface{}
+}
+
+type callOptionsKey struct{}
+
+func newRequestOptions(opts []RequestOption) *requestOptions {
+	o := &requestOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// apply stores options affecting the middleware chain in ctx
+func (o *requestOptions) apply(ctx context.Context) context.Context {
+	for _, v := range o.values {
+		ctx = context.WithValue(ctx, v.key, v.value)
+	}
+	if len(o.call.middleware) == 0 && len(o.call.disabled) == 0 && o.call.timeout == 0 {
+		return ctx
+	}
+	call := o.call
+	if prev := callOptionsFromContext(ctx); prev != nil {
+		call.middleware = append(append([]MiddlewareFunc{}, prev.middleware...), o.call.middleware...)
+		call.disabled = map[string]bool{}
+		for name := range prev.disabled {
+			call.disabled[name] = true
+		}
+		for name := range o.call.disabled {
+			call.disabled[name] = true
+		}
+		if call.timeout == 0 {
+			call.timeout = prev.timeout
+		}
+	}
+	return context.WithValue(ctx, callOptionsKey{}, &call)
+}
+
+func callOptionsFromContext(ctx context.Context) *callOptions {
+	if ctx == nil {
+		return nil
+	}
+	o, _ := ctx.Value(callOptionsKey{}).(*callOptions)
+	return o
+}
+
+// WithRequestOptions returns a copy of ctx carrying request options affecting
+// the middleware chain. It allows using request options with requests created
+// without NewRequest, e.g. by http.NewRequestWithContext.
+func WithRequestOptions(ctx context.Context, opts ...RequestOption) context.Context {
+	return newRequestOptions(opts).apply(ctx)
+}
+
+// WithContentType sets the content type of the request body. The body encoder is
+// chosen from the codec registry (see WithCodecs) by this content type.
+// The default content type is "application/json".
+func WithContentType(contentType string) RequestOption {
+	return func(o *requestOptions) {
+		o.contentType = contentType
+	}
+}
+
+// WithAccept sets the Accept header of the request. The default is "application/json".
+func WithAccept(accept string) RequestOption {
+	return func(o *requestOptions) {
+		o.accept = accept
+	}
+}
+
+// WithMiddleware adds middleware applied to a single request. The request middleware
+// runs before the middleware registered with Client.Use.
+func WithMiddleware(middleware ...MiddlewareFunc) RequestOption {
+	return func(o *requestOptions) {
+		o.call.middleware = append(o.call.middleware, middleware...)
+	}
+}
+
+// WithoutMiddleware disables named middleware (see Named) for a single request.
+func WithoutMiddleware(names ...string) RequestOption {
+	return func(o *requestOptions) {
+		if o.call.disabled == nil {
+			o.call.disabled = map[string]bool{}
+		}
+		for _, name := range names {
+			o.call.disabled[name] = true
+		}
+	}
+}
+
+// WithTimeout sets the timeout of a single request replacing Client.Timeout. The timeout includes
+// all middleware (e.g. retries) and reading of the response body.
+func WithTimeout(timeout time.Duration) RequestOption {
+	return func(o *requestOptions) {
+		o.call.timeout = timeout
+	}
+}
+
+// WithContextValue stores the value in the request context. Middleware uses it to
+// provide its own request options, e.g. retry configuration overrides.
+func WithContextValue(key, value interface{}) RequestOption {
+	return func(o *requestOptions) {
+		o.values = append(o.values, contextValue{key: key, value: value})
+	}
+}
+
+// MiddlewareDisabled reports whether named middleware is disabled for the request with ctx.
+func MiddlewareDisabled(ctx context.Context, name string) bool {
+	o := callOptionsFromContext(ctx)
+	return o != nil && o.disabled[name]
+}
+
+// Named wraps middleware so it can be disabled for a single request by WithoutMiddleware.
+// Register it with Client.UseNamed to reorder, replace or remove it by name.
+func Named(name string, middleware MiddlewareFunc) MiddlewareFunc {
+	return func(c *http.Client, next Responder) Responder {
+		h := middleware(c, next)
+		return func(request *http.Request) (*http.Response, error) {
+			if MiddlewareDisabled(request.Context(), name) {
+				return next(request)
+			}
+			return h(request)
+		}
+	}
+}
+
+// cancelBody cancels request context when the response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
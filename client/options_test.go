@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type testKey struct{}
+
+func TestRequestOptions(t *testing.T) {
+	mock := createMock(url, wantStatusCode, wantBody)
+	ctx := context.Background()
+	headerMiddleware := func(c *http.Client, next Responder) Responder {
+		return func(request *http.Request) (*http.Response, error) {
+			request.Header.Set("X-Request", "1")
+			return next(request)
+		}
+	}
+
+	t.Run("Should apply request middleware to a single request", func(t *testing.T) {
+		richClient := NewClient(mock)
+		var got []string
+		richClient.Use(func(c *http.Client, next Responder) Responder {
+			return func(request *http.Request) (*http.Response, error) {
+				got = append(got, request.Header.Get("X-Request"))
+				return next(request)
+			}
+		})
+		req, err := NewHTTPRequest(ctx, http.MethodGet, url, nil, WithMiddleware(headerMiddleware))
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		response, err := richClient.Client.Do(req)
+		assertResponse(t, response, err)
+		response, err = richClient.Client.Get(url)
+		assertResponse(t, response, err)
+		if len(got) != 2 || got[0] != "1" || got[1] != "" {
+			t.Errorf("got headers %v", got)
+		}
+	})
+	t.Run("Should disable named middleware", func(t *testing.T) {
+		richClient := NewClient(mock)
+		richClient.Use(Named("conflict", createMiddleware(http.MethodGet, http.StatusConflict)))
+		req, err := NewHTTPRequest(ctx, http.MethodGet, url, nil, WithoutMiddleware("conflict"))
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		response, err := richClient.Client.Do(req)
+		assertResponse(t, response, err)
+
+		response, err = richClient.Client.Get(url)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if response.StatusCode != http.StatusConflict {
+			t.Errorf("got %d, want %d", response.StatusCode, http.StatusConflict)
+		}
+	})
+	t.Run("Should override request timeout", func(t *testing.T) {
+		richClient := NewClient(mock)
+		richClient.Use(func(c *http.Client, next Responder) Responder {
+			return func(request *http.Request) (*http.Response, error) {
+				<-request.Context().Done()
+				return nil, request.Context().Err()
+			}
+		})
+		_, err := GetAs[typedModel](ctx, richClient, url, WithTimeout(10*time.Millisecond))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded error but got %v", err)
+		}
+	})
+	t.Run("Should replace default timeout", func(t *testing.T) {
+		richClient := NewClient(mock)
+		richClient.Timeout = 10 * time.Millisecond
+		richClient.Use(func(c *http.Client, next Responder) Responder {
+			return func(request *http.Request) (*http.Response, error) {
+				select {
+				case <-request.Context().Done():
+					return nil, request.Context().Err()
+				case <-time.After(50 * time.Millisecond):
+					return next(request)
+				}
+			}
+		})
+		if _, err := richClient.Client.Get(url); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded error but got %v", err)
+		}
+		req, err := NewHTTPRequest(ctx, http.MethodGet, url, nil, WithTimeout(time.Second))
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		response, err := richClient.Client.Do(req)
+		assertResponse(t, response, err)
+	})
+	t.Run("Should merge options from context", func(t *testing.T) {
+		reqCtx := WithRequestOptions(ctx, WithoutMiddleware("a"))
+		reqCtx = WithRequestOptions(reqCtx, WithoutMiddleware("b"), WithContextValue(testKey{}, "value"))
+		if !MiddlewareDisabled(reqCtx, "a") || !MiddlewareDisabled(reqCtx, "b") || MiddlewareDisabled(reqCtx, "c") {
+			t.Errorf("options should be merged")
+		}
+		if reqCtx.Value(testKey{}) != "value" {
+			t.Errorf("context value should be set")
+		}
+	})
+}
@@ -0,0 +1,334 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMissingPathParam is returned when a path template has a parameter without value.
+	ErrMissingPathParam = errors.New("missing path parameter")
+
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+type baseURLKey struct{}
+
+type routeKey struct{}
+
+// WithBaseURL sets the base URL the relative request URL is resolved against.
+// It takes precedence over Client.BaseURL.
+func WithBaseURL(baseURL string) RequestOption {
+	return func(o *requestOptions) {
+		o.baseURL = baseURL
+	}
+}
+
+// WithPathParam sets the value of the path template parameter, e.g. "id" for "/users/{id}".
+// The value is formatted with fmt.Sprint and escaped.
+func WithPathParam(name string, value interface{}) RequestOption {
+	return func(o *requestOptions) {
+		if o.pathParams == nil {
+			o.pathParams = map[string]string{}
+		}
+		o.pathParams[name] = fmt.Sprint(value)
+	}
+}
+
+// WithPathParams sets values of the path template parameters.
+func WithPathParams(params map[string]string) RequestOption {
+	return func(o *requestOptions) {
+		for name, value := range params {
+			WithPathParam(name, value)(o)
+		}
+	}
+}
+
+// WithQuery adds query parameters to the request URL. The params can be neturl.Values,
+// map[string]string, map[string][]string or a struct encoded by EncodeQuery.
+func WithQuery(params interface{}) RequestOption {
+	return func(o *requestOptions) {
+		o.query = append(o.query, params)
+	}
+}
+
+// WithRoute sets the route template of the request, e.g. "/users/{id}".
+// Middleware uses it to group requests (e.g. metrics labels) without raw URLs.
+// By default the route is the path of the request URL template with path parameters.
+func WithRoute(route string) RequestOption {
+	return WithContextValue(routeKey{}, route)
+}
+
+// RouteFromContext returns the route template of the request (see WithRoute).
+// It returns an empty string if the request URL is not a template.
+func RouteFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	route, _ := ctx.Value(routeKey{}).(string)
+	return route
+}
+
+// routeTemplate returns the path of the URL template with path parameters
+func routeTemplate(rawURL string) string {
+	if !strings.Contains(rawURL, "{") {
+		return ""
+	}
+	if i := strings.IndexAny(rawURL, "?#"); i >= 0 {
+		rawURL = rawURL[:i]
+	}
+	if i := strings.Index(rawURL, "://"); i >= 0 {
+		rawURL = rawURL[i+3:]
+		if j := strings.IndexByte(rawURL, '/'); j >= 0 {
+			return rawURL[j:]
+		}
+		return "/"
+	}
+	return rawURL
+}
+
+// baseURLFromContext returns the client base URL stored in ctx
+func baseURLFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	base, _ := ctx.Value(baseURLKey{}).(string)
+	return base
+}
+
+// buildURL expands the path template, resolves it against the base URL and adds query parameters
+func buildURL(ctx context.Context, rawURL string, o *requestOptions) (string, error) {
+	base := o.baseURL
+	if base == "" {
+		base = baseURLFromContext(ctx)
+	}
+	if base == "" && len(o.pathParams) == 0 && len(o.query) == 0 {
+		return rawURL, nil
+	}
+	expanded, err := expandPath(rawURL, o.pathParams)
+	if err != nil {
+		return "", err
+	}
+	u, err := neturl.Parse(expanded)
+	if err != nil {
+		return "", err
+	}
+	if base != "" && !u.IsAbs() {
+		b, err := neturl.Parse(base)
+		if err != nil {
+			return "", err
+		}
+		b.Path = joinPath(b.Path, u.Path)
+		if u.RawPath != "" || b.RawPath != "" {
+			b.RawPath = joinPath(b.EscapedPath(), u.EscapedPath())
+		}
+		b.RawQuery = u.RawQuery
+		b.Fragment = u.Fragment
+		u = b
+	}
+	if len(o.query) > 0 {
+		values := u.Query()
+		for _, q := range o.query {
+			encoded, err := EncodeQuery(q)
+			if err != nil {
+				return "", err
+			}
+			for k, v := range encoded {
+				values[k] = append(values[k], v...)
+			}
+		}
+		u.RawQuery = values.Encode()
+	}
+	return u.String(), nil
+}
+
+func joinPath(base, ref string) string {
+	if ref == "" {
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(ref, "/")
+}
+
+// expandPath replaces {name} placeholders with escaped parameter values
+func expandPath(template string, params map[string]string) (string, error) {
+	var sb strings.Builder
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			sb.WriteString(template)
+			return sb.String(), nil
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unclosed path parameter in %q", template)
+		}
+		end += start
+		name := template[start+1 : end]
+		value, ok := params[name]
+		if !ok {
+			return "", fmt.Errorf("%w %q", ErrMissingPathParam, name)
+		}
+		sb.WriteString(template[:start])
+		sb.WriteString(neturl.PathEscape(value))
+		template = template[end+1:]
+	}
+}
+
+// EncodeQuery encodes params into neturl.Values. The params can be neturl.Values,
+// map[string]string, map[string][]string or a struct (or a pointer to struct).
+//
+// Struct fields are encoded by the "url" tag: `url:"name,omitempty"`.
+// Fields with tag "-" are skipped, fields without tag use the field name.
+// Slices and arrays are encoded as repeated parameters, or as a single comma
+// separated parameter with the "comma" option. time.Time values are encoded
+// in RFC 3339 format, or as unix seconds with the "unix" option.
+// Embedded structs are flattened.
+func EncodeQuery(params interface{}) (neturl.Values, error) {
+	switch p := params.(type) {
+	case nil:
+		return neturl.Values{}, nil
+	case neturl.Values:
+		return p, nil
+	case map[string][]string:
+		return p, nil
+	case map[string]string:
+		values := neturl.Values{}
+		for k, v := range p {
+			values.Set(k, v)
+		}
+		return values, nil
+	}
+	v, ok := indirect(reflect.ValueOf(params))
+	if !ok {
+		return neturl.Values{}, nil
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w %T for query parameters", ErrUnsupportedValue, params)
+	}
+	values := neturl.Values{}
+	if err := encodeStruct(values, v); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func encodeStruct(values neturl.Values, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("url")
+		if tag == "-" {
+			continue
+		}
+		name, opts := parseTag(tag)
+		fv, ok := indirect(v.Field(i))
+		if field.Anonymous && name == "" && fv.Kind() == reflect.Struct && fv.Type() != timeType {
+			if ok {
+				if err := encodeStruct(values, fv); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if field.PkgPath != "" || !ok {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if opts["omitempty"] && v.Field(i).IsZero() {
+			continue
+		}
+		if (fv.Kind() == reflect.Slice || fv.Kind() == reflect.Array) && fv.Type().Elem().Kind() != reflect.Uint8 {
+			items := make([]string, 0, fv.Len())
+			for j := 0; j < fv.Len(); j++ {
+				s, err := formatValue(fv.Index(j), opts)
+				if err != nil {
+					return fmt.Errorf("query parameter %q: %w", name, err)
+				}
+				items = append(items, s)
+			}
+			if opts["comma"] {
+				values.Add(name, strings.Join(items, ","))
+			} else {
+				values[name] = append(values[name], items...)
+			}
+			continue
+		}
+		s, err := formatValue(fv, opts)
+		if err != nil {
+			return fmt.Errorf("query parameter %q: %w", name, err)
+		}
+		values.Add(name, s)
+	}
+	return nil
+}
+
+// indirect dereferences pointers and interfaces and reports if the value is not nil
+func indirect(v reflect.Value) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			if v.Kind() == reflect.Ptr {
+				// keep the type information for embedded structs
+				return reflect.Zero(v.Type().Elem()), false
+			}
+			return v, false
+		}
+		v = v.Elem()
+	}
+	return v, true
+}
+
+func parseTag(tag string) (string, map[string]bool) {
+	parts := strings.Split(tag, ",")
+	opts := map[string]bool{}
+	for _, o := range parts[1:] {
+		opts[o] = true
+	}
+	return parts[0], opts
+}
+
+func formatValue(v reflect.Value, opts map[string]bool) (string, error) {
+	v, ok := indirect(v)
+	if !ok {
+		return "", nil
+	}
+	if !v.CanInterface() {
+		return "", fmt.Errorf("%w unexported %s", ErrUnsupportedValue, v.Type())
+	}
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		if opts["unix"] {
+			return strconv.FormatInt(t.Unix(), 10), nil
+		}
+		return t.Format(time.RFC3339), nil
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok {
+		return s.String(), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes()), nil
+		}
+	}
+	return "", fmt.Errorf("%w %s", ErrUnsupportedValue, v.Type())
+}
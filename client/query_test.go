@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type pageQuery struct {
+	Page  int `url:"page,omitempty"`
+	Limit int `url:"limit"`
+}
+
+type searchQuery struct {
+	pageQuery
+	Text     string    `url:"q"`
+	Tags     []string  `url:"tag"`
+	IDs      []int     `url:"id,comma"`
+	Since    time.Time `url:"since"`
+	Until    time.Time `url:"until,unix,omitempty"`
+	Archived *bool     `url:"archived,omitempty"`
+	Internal string    `url:"-"`
+}
+
+func TestEncodeQuery(t *testing.T) {
+	archived := false
+	since := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	values, err := EncodeQuery(&searchQuery{
+		pageQuery: pageQuery{Limit: 10},
+		Text:      "a&b",
+		Tags:      []string{"x", "y"},
+		IDs:       []int{1, 2},
+		Since:     since,
+		Archived:  &archived,
+		Internal:  "secret",
+	})
+	if err != nil {
+		t.Fatalf("did not expect an error but got one %v", err)
+	}
+	want := "archived=false&id=1%2C2&limit=10&q=a%26b&since=2021-05-01T10%3A00%3A00Z&tag=x&tag=y"
+	if got := values.Encode(); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if _, err = EncodeQuery(42); !errors.Is(err, ErrUnsupportedValue) {
+		t.Errorf("expected ErrUnsupportedValue but got %v", err)
+	}
+}
+
+func TestBuildURL(t *testing.T) {
+	ctx := context.Background()
+	t.Run("Should expand path template and add query", func(t *testing.T) {
+		req, err := NewRequest(ctx, http.MethodGet, "https://api.example.com/users/{id}/files/{name}?v=1", nil,
+			WithPathParam("id", 42), WithPathParam("name", "a b/c"), WithQuery(pageQuery{Page: 2, Limit: 5}))
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		want := "https://api.example.com/users/42/files/a%20b%2Fc?limit=5&page=2&v=1"
+		if got := req.URL.String(); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	})
+	t.Run("Should fail on missing path parameter", func(t *testing.T) {
+		_, err := NewRequest(ctx, http.MethodGet, "https://api.example.com/users/{id}", nil, WithPathParam("name", 1))
+		if !errors.Is(err, ErrMissingPathParam) {
+			t.Errorf("expected ErrMissingPathParam but got %v", err)
+		}
+	})
+	t.Run("Should resolve relative URL against base URL", func(t *testing.T) {
+		tests := map[string]string{
+			"/users/{id}":                          "https://api.example.com/v1/users/7",
+			"users/{id}":                           "https://api.example.com/v1/users/7",
+			"https://other.example.com/users/{id}": "https://other.example.com/users/7",
+		}
+		for path, want := range tests {
+			req, err := NewRequest(ctx, http.MethodGet, path, nil,
+				WithBaseURL("https://api.example.com/v1/"), WithPathParam("id", 7))
+			if err != nil {
+				t.Fatalf("did not expect an error but got one %v", err)
+			}
+			if got := req.URL.String(); got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		}
+	})
+	t.Run("Should use client base URL", func(t *testing.T) {
+		mock := createMock("https://www.example.com/api/items/1?limit=3", wantStatusCode, `{"id":1}`)
+		richClient := NewClient(mock)
+		richClient.BaseURL = "https://www.example.com/api"
+		resp, err := GetAs[typedModel](ctx, richClient, "/items/{id}",
+			WithPathParam("id", 1), WithQuery(pageQuery{Limit: 3}))
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if resp.Value.ID != 1 {
+			t.Errorf("got %d, want %d", resp.Value.ID, 1)
+		}
+		var response typedModel
+		if err = richClient.Get("/items/1?limit=3", &response); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+	})
+}
+
+func TestRouteFromContext(t *testing.T) {
+	ctx := context.Background()
+	tests := map[string]string{
+		"https://api.example.com/users/{id}?v=1": "/users/{id}",
+		"users/{id}/files":                       "users/{id}/files",
+		"https://api.example.com/users/42":       "",
+	}
+	for rawURL, want := range tests {
+		req, err := NewRequest(ctx, http.MethodGet, rawURL, nil, WithPathParam("id", 42))
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if got := RouteFromContext(req.Context()); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+	req, _ := NewRequest(ctx, http.MethodGet, "https://api.example.com/users/42", nil, WithRoute("/users/:id"))
+	if got := RouteFromContext(req.Context()); got != "/users/:id" {
+		t.Errorf("got %q, want %q", got, "/users/:id")
+	}
+}
@@ -3,7 +3,6 @@ package client
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -38,7 +37,7 @@ func (r *Request) WithContext(ctx context.Context) *Request {
 	return r
 }
 
-func getBodyReaderAndContentLength(rawBody interface{}) (ReaderFunc, int64, error) {
+func getBodyReaderAndContentLength(rawBody interface{}, encode func(interface{}) ([]byte, error)) (ReaderFunc, int64, error) {
 	var bodyReader ReaderFunc
 	var contentLength int64
 	switch body := rawBody.(type) {
@@ -109,9 +108,9 @@ func getBodyReaderAndContentLength(rawBody interface{}) (ReaderFunc, int64, erro
 	// No body provided, nothing to do
 	case nil:
 		return nil, 0, nil
-	// json object
+	// object serialized by codec
 	default:
-		buf, err := json.Marshal(rawBody)
+		buf, err := encode(rawBody)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -124,21 +123,49 @@ func getBodyReaderAndContentLength(rawBody interface{}) (ReaderFunc, int64, erro
 	return bodyReader, contentLength, nil
 }
 
-func getBodyReaderAndRequest(ctx context.Context, method, url string, rawBody interface{}) (*http.Request, ReaderFunc, error) {
-	bodyReader, contentLength, err := getBodyReaderAndContentLength(rawBody)
+func getBodyReaderAndRequest(ctx context.Context, method, url string, rawBody interface{}, opts []RequestOption) (*http.Request, ReaderFunc, error) {
+	o := newRequestOptions(opts)
+	contentType := o.contentType
+	if contentType == "" {
+		contentType = jsonContentType
+	}
+	encode := func(v interface{}) ([]byte, error) {
+		codec, err := CodecsFromContext(ctx).Lookup(contentType)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Encode(v)
+	}
+	bodyReader, contentLength, err := getBodyReaderAndContentLength(rawBody, encode)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, method, url, nil)
+	reqCtx := o.apply(ctx)
+	if route := routeTemplate(url); route != "" && RouteFromContext(reqCtx) == "" {
+		reqCtx = context.WithValue(reqCtx, routeKey{}, route)
+	}
+	url, err = buildURL(ctx, url, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(reqCtx, method, url, nil)
 	if err != nil {
 		return nil, nil, err
 	}
 	httpReq.ContentLength = contentLength
 	if bodyReader != nil {
-		httpReq.Header.Add("Content-Type", fmt.Sprintf("%s; charset=utf-8", jsonContentType))
+		if o.contentType == "" {
+			httpReq.Header.Add("Content-Type", fmt.Sprintf("%s; charset=utf-8", jsonContentType))
+		} else {
+			httpReq.Header.Add("Content-Type", o.contentType)
+		}
+	}
+	accept := o.accept
+	if accept == "" {
+		accept = jsonContentType
 	}
-	httpReq.Header.Add("Accept", jsonContentType)
+	httpReq.Header.Add("Accept", accept)
 	return httpReq, bodyReader, nil
 }
 
@@ -166,7 +193,7 @@ func (r *Request) RewindBody() error {
 
 // FromRequest wraps a http.Request in a retryablehttp.Request
 func FromRequest(r *http.Request) (*Request, error) {
-	bodyReader, _, err := getBodyReaderAndContentLength(r.Body)
+	bodyReader, _, err := getBodyReaderAndContentLength(r.Body, JSONCodec{}.Encode)
 	if err != nil {
 		return nil, err
 	}
@@ -175,8 +202,8 @@ func FromRequest(r *http.Request) (*Request, error) {
 }
 
 // NewHTTPRequest creates new http.Request with default header
-func NewHTTPRequest(ctx context.Context, method, url string, rawBody interface{}) (*http.Request, error) {
-	httpReq, bodyReader, err := getBodyReaderAndRequest(ctx, method, url, rawBody)
+func NewHTTPRequest(ctx context.Context, method, url string, rawBody interface{}, opts ...RequestOption) (*http.Request, error) {
+	httpReq, bodyReader, err := getBodyReaderAndRequest(ctx, method, url, rawBody, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -188,8 +215,8 @@ func NewHTTPRequest(ctx context.Context, method, url string, rawBody interface{}
 }
 
 // NewRequest creates a new wrapped request.
-func NewRequest(ctx context.Context, method, url string, rawBody interface{}) (*Request, error) {
-	httpReq, bodyReader, err := getBodyReaderAndRequest(ctx, method, url, rawBody)
+func NewRequest(ctx context.Context, method, url string, rawBody interface{}, opts ...RequestOption) (*Request, error) {
+	httpReq, bodyReader, err := getBodyReaderAndRequest(ctx, method, url, rawBody, opts)
 	if err != nil {
 		return nil, err
 	}
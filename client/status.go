@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// StatusClass is a classification of the HTTP response status made by StatusPolicy.
+type StatusClass int
+
+// These constants are classes of HTTP response status.
+const (
+	// StatusClassSuccess means the response is successful and the body should be deserialized
+	StatusClassSuccess StatusClass = iota
+	// StatusClassEmptySuccess means the response is successful, but the body should be ignored
+	StatusClassEmptySuccess
+	// StatusClassError means the response is not successful
+	StatusClassError
+)
+
+// String implements stringer interface.
+func (c StatusClass) String() string {
+	switch c {
+	case StatusClassSuccess:
+		return "success"
+	case StatusClassEmptySuccess:
+		return "empty-success"
+	case StatusClassError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// StatusPolicy specifies a policy deciding if the response is successful.
+// It is consulted by ReadResponse and by the middleware (e.g. Retry, CircuitBreaker).
+type StatusPolicy func(resp *http.Response) StatusClass
+
+type statusPolicyKey struct{}
+
+// DefaultStatusPolicy treats 2xx status codes as success. 204 No Content,
+// 205 Reset Content and 304 Not Modified are successful without body.
+// All other status codes are errors.
+func DefaultStatusPolicy(resp *http.Response) StatusClass {
+	switch {
+	case resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusResetContent ||
+		resp.StatusCode == http.StatusNotModified:
+		return StatusClassEmptySuccess
+	case resp.StatusCode >= http.StatusOK && resp.StatusCode <= 299:
+		return StatusClassSuccess
+	default:
+		return StatusClassError
+	}
+}
+
+// LenientStatusPolicy works as DefaultStatusPolicy, but also treats 404 Not Found
+// as successful response without body.
+func LenientStatusPolicy(resp *http.Response) StatusClass {
+	if resp.StatusCode == http.StatusNotFound {
+		return StatusClassEmptySuccess
+	}
+	return DefaultStatusPolicy(resp)
+}
+
+// WithStatusPolicy returns a copy of ctx carrying StatusPolicy for a single request.
+// The policy from the request context takes precedence over Client.StatusPolicy.
+func WithStatusPolicy(ctx context.Context, policy StatusPolicy) context.Context {
+	return context.WithValue(ctx, statusPolicyKey{}, policy)
+}
+
+// StatusPolicyFromContext returns StatusPolicy stored in ctx or nil.
+func StatusPolicyFromContext(ctx context.Context) StatusPolicy {
+	if ctx == nil {
+		return nil
+	}
+	policy, _ := ctx.Value(statusPolicyKey{}).(StatusPolicy)
+	return policy
+}
+
+// ClassifyResponse classifies response with StatusPolicy of the originating request
+// or DefaultStatusPolicy when the request does not have one.
+func ClassifyResponse(resp *http.Response) StatusClass {
+	policy := DefaultStatusPolicy
+	if resp.Request != nil {
+		if p := StatusPolicyFromContext(resp.Request.Context()); p != nil {
+			policy = p
+		}
+	}
+	return policy(resp)
+}
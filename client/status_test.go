@@ -0,0 +1,71 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestStatusPolicy(t *testing.T) {
+	notFoundURL := "https://www.example.com/missing"
+	mock := NewMockTransport(true)
+	mock.RegisterResponder(http.MethodGet, notFoundURL,
+		func(request *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusNotFound,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"id":1}`)),
+				Header:     make(http.Header),
+			}, nil
+		})
+
+	t.Run("Should classify status codes with DefaultStatusPolicy", func(t *testing.T) {
+		tests := map[int]StatusClass{
+			http.StatusOK:                  StatusClassSuccess,
+			http.StatusCreated:             StatusClassSuccess,
+			http.StatusNoContent:           StatusClassEmptySuccess,
+			http.StatusNotModified:         StatusClassEmptySuccess,
+			http.StatusNotFound:            StatusClassError,
+			http.StatusInternalServerError: StatusClassError,
+		}
+		for code, want := range tests {
+			got := ClassifyResponse(&http.Response{StatusCode: code})
+			if got != want {
+				t.Errorf("status %d: got %s, want %s", code, got, want)
+			}
+		}
+	})
+	t.Run("Should return error on 404 by default", func(t *testing.T) {
+		c := NewClient(mock)
+		var response typedModel
+		err := c.Get(notFoundURL, &response)
+		if !IsNotFound(err) {
+			t.Errorf("expected not found error but got %v", err)
+		}
+	})
+	t.Run("Should use client StatusPolicy", func(t *testing.T) {
+		c := NewClient(mock)
+		c.StatusPolicy = LenientStatusPolicy
+		var response typedModel
+		if err := c.Get(notFoundURL, &response); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if response.ID != 0 {
+			t.Errorf("response body should not be decoded")
+		}
+	})
+	t.Run("Should prefer request StatusPolicy", func(t *testing.T) {
+		c := NewClient(mock)
+		c.StatusPolicy = LenientStatusPolicy
+		success := func(*http.Response) StatusClass { return StatusClassSuccess }
+		ctx := WithStatusPolicy(context.Background(), success)
+		resp, err := GetAs[typedModel](ctx, c, notFoundURL)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if resp.Value.ID != 1 {
+			t.Errorf("got %d, want %d", resp.Value.ID, 1)
+		}
+	})
+}
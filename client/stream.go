@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// Content types of newline delimited JSON streams.
+const (
+	ContentTypeNDJSON = "application/x-ndjson"
+	ContentTypeJSONL  = "application/jsonl"
+)
+
+type maxBodySizeKey struct{}
+
+// BodyTooLargeError is returned when the response body exceeds the configured maximum size.
+type BodyTooLargeError struct {
+	// Limit is the maximum allowed body size in bytes
+	Limit int64
+}
+
+// Error implements error interface.
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("response body exceeds maximum size of %d bytes", e.Limit)
+}
+
+// WithMaxBodySize returns a copy of ctx carrying the maximum size of the response body
+// decoded for a single request. Zero or negative size means unlimited.
+// The size from the request context takes precedence over Client.MaxBodySize.
+func WithMaxBodySize(ctx context.Context, size int64) context.Context {
+	return context.WithValue(ctx, maxBodySizeKey{}, size)
+}
+
+// MaxBodySizeFromContext returns the maximum size of the response body stored in ctx
+// and reports if it was set.
+func MaxBodySizeFromContext(ctx context.Context) (int64, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	size, ok := ctx.Value(maxBodySizeKey{}).(int64)
+	return size, ok
+}
+
+// maxBytesReader fails with BodyTooLargeError after reading more than limit bytes
+type maxBytesReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (l *maxBytesReader) Read(p []byte) (int, error) {
+	if l.read > l.limit {
+		return 0, &BodyTooLargeError{Limit: l.limit}
+	}
+	// read one extra byte to detect overflow
+	if remain := l.limit - l.read + 1; int64(len(p)) > remain {
+		p = p[:remain]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n - int(l.read-l.limit), &BodyTooLargeError{Limit: l.limit}
+	}
+	return n, err
+}
+
+// limitBody wraps body with reader failing after the maximum body size from ctx
+func limitBody(ctx context.Context, body io.Reader) io.Reader {
+	if size, ok := MaxBodySizeFromContext(ctx); ok && size > 0 {
+		return &maxBytesReader{r: body, limit: size}
+	}
+	return body
+}
+
+// Stream iterates over elements of a top-level JSON array or a newline delimited JSON
+// (NDJSON) response body without buffering the whole body.
+//
+//	s, err := client.StreamJSON[Item](ctx, c, http.MethodGet, url, nil)
+//	if err != nil {
+//		return err
+//	}
+//	defer s.Close()
+//	for s.Next() {
+//		item := s.Value()
+//	}
+//	return s.Err()
+type Stream[T any] struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// Header is the response header
+	Header http.Header
+
+	body    io.ReadCloser
+	dec     *json.Decoder
+	ndjson  bool
+	started bool
+	done    bool
+	value   T
+	err     error
+}
+
+// StreamJSON sends an HTTP request through the Client middleware chain and returns
+// Stream over elements of the response body.
+func StreamJSON[T any](ctx context.Context, c *Client, method, url string, rawBody interface{}, opts ...RequestOption) (*Stream[T], error) {
+	if c == nil {
+		c = defaultClient
+	}
+	req, err := NewHTTPRequest(c.withClientContext(ctx), method, url, rawBody, opts...)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return NewStream[T](resp)
+}
+
+// NewStream creates Stream over elements of the response body. Responses with
+// "application/x-ndjson" or "application/jsonl" content type are read as NDJSON,
+// all others as a top-level JSON array. The caller must close the Stream.
+func NewStream[T any](resp *http.Response) (*Stream[T], error) {
+	s := &Stream[T]{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		body:       resp.Body,
+	}
+	switch ClassifyResponse(resp) {
+	case StatusClassError:
+		defer DrainBody(resp.Body)
+		return nil, readErrorBody(NewHTTPError(resp), resp.Body)
+	case StatusClassEmptySuccess:
+		s.done = true
+		return s, nil
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		s.ndjson = mediaType == ContentTypeNDJSON || mediaType == ContentTypeJSONL
+	}
+	ctx := context.Background()
+	if resp.Request != nil {
+		ctx = resp.Request.Context()
+	}
+	s.dec = json.NewDecoder(limitBody(ctx, resp.Body))
+	return s, nil
+}
+
+// Next decodes the next element, which is then available through the Value method.
+// It returns false when the stream ends or an error occurs (see Err).
+func (s *Stream[T]) Next() bool {
+	if s.done || s.err != nil {
+		return false
+	}
+	if !s.started {
+		s.started = true
+		if !s.ndjson {
+			if err := s.expectDelim('['); err != nil {
+				s.err = err
+				return false
+			}
+		}
+	}
+	if !s.dec.More() {
+		s.done = true
+		if !s.ndjson {
+			s.err = s.expectDelim(']')
+		}
+		return false
+	}
+	var value T
+	if err := s.dec.Decode(&value); err != nil {
+		if s.ndjson && err == io.EOF {
+			s.done = true
+			return false
+		}
+		s.err = err
+		return false
+	}
+	s.value = value
+	return true
+}
+
+// Value returns the most recent element decoded by Next.
+func (s *Stream[T]) Value() T {
+	return s.value
+}
+
+// Err returns the first error that was encountered by the Stream.
+func (s *Stream[T]) Err() error {
+	return s.err
+}
+
+// Close drains and closes the response body.
+func (s *Stream[T]) Close() error {
+	s.done = true
+	DrainBody(s.body)
+	return nil
+}
+
+func (s *Stream[T]) expectDelim(delim json.Delim) error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected JSON %s but got %v", delim, tok)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	streamURL := "https://www.example.com/export"
+	newClient := func(contentType, body string) *Client {
+		mock := NewMockTransport(true)
+		mock.RegisterResponder(http.MethodGet, streamURL,
+			func(request *http.Request) (*http.Response, error) {
+				header := make(http.Header)
+				header.Set("Content-Type", contentType)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewBufferString(body)),
+					Header:     header,
+				}, nil
+			})
+		return NewClient(mock)
+	}
+	collect := func(t testing.TB, s *Stream[typedModel]) []int {
+		t.Helper()
+		defer s.Close()
+		var ids []int
+		for s.Next() {
+			ids = append(ids, s.Value().ID)
+		}
+		if err := s.Err(); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		return ids
+	}
+	ctx := context.Background()
+
+	t.Run("Should iterate over top-level JSON array", func(t *testing.T) {
+		c := newClient(ContentTypeJSON, `[{"id":1},{"id":2},{"id":3}]`)
+		s, err := StreamJSON[typedModel](ctx, c, http.MethodGet, streamURL, nil)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if ids := collect(t, s); len(ids) != 3 || ids[2] != 3 {
+			t.Errorf("got %v", ids)
+		}
+	})
+	t.Run("Should iterate over NDJSON", func(t *testing.T) {
+		c := newClient(ContentTypeNDJSON, "{\"id\":1}\n{\"id\":2}\n")
+		s, err := StreamJSON[typedModel](ctx, c, http.MethodGet, streamURL, nil)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if ids := collect(t, s); len(ids) != 2 || ids[1] != 2 {
+			t.Errorf("got %v", ids)
+		}
+	})
+	t.Run("Should fail on not array body", func(t *testing.T) {
+		c := newClient(ContentTypeJSON, `{"id":1}`)
+		s, err := StreamJSON[typedModel](ctx, c, http.MethodGet, streamURL, nil)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		defer s.Close()
+		if s.Next() || s.Err() == nil {
+			t.Errorf("should error")
+		}
+	})
+	t.Run("Should fail with BodyTooLargeError", func(t *testing.T) {
+		body := "[" + strings.Repeat(`{"id":1},`, 100) + `{"id":1}]`
+		c := newClient(ContentTypeJSON, body)
+		c.MaxBodySize = 64
+
+		var bodyErr *BodyTooLargeError
+		var response []typedModel
+		if err := c.Get(streamURL, &response); !errors.As(err, &bodyErr) {
+			t.Errorf("expected BodyTooLargeError but got %v", err)
+		}
+		if _, err := GetAs[[]typedModel](ctx, c, streamURL); !errors.As(err, &bodyErr) {
+			t.Errorf("expected BodyTooLargeError but got %v", err)
+		}
+		s, err := StreamJSON[typedModel](ctx, c, http.MethodGet, streamURL, nil)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		defer s.Close()
+		for s.Next() {
+		}
+		if !errors.As(s.Err(), &bodyErr) || bodyErr.Limit != 64 {
+			t.Errorf("expected BodyTooLargeError but got %v", s.Err())
+		}
+	})
+	t.Run("Should prefer request maximum body size", func(t *testing.T) {
+		c := newClient(ContentTypeJSON, `[{"id":1},{"id":2}]`)
+		c.MaxBodySize = 4
+		resp, err := GetAs[[]typedModel](WithMaxBodySize(ctx, 0), c, streamURL)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if len(resp.Value) != 2 {
+			t.Errorf("got %v", resp.Value)
+		}
+	})
+}
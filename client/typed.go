@@ -0,0 +1,108 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+)
+
+// Response is a typed response envelope returned by Do and Send.
+type Response[T any] struct {
+	// Value is the deserialized response body
+	Value T
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// Status is the HTTP status line of the response, e.g. "200 OK"
+	Status string
+	// Header is the response header
+	Header http.Header
+}
+
+// Do sends an HTTP request with the given method through the Client middleware chain
+// and returns a Response with the body deserialized into a value of type T.
+// The rawBody argument accepts the same types as NewRequest.
+func Do[T any](ctx context.Context, c *Client, method, url string, rawBody interface{}, opts ...RequestOption) (*Response[T], error) {
+	if c == nil {
+		c = defaultClient
+	}
+	req, err := NewHTTPRequest(c.withClientContext(ctx), method, url, rawBody, opts...)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return readTypedResponse[T](resp)
+}
+
+// Send sends an HTTP request with a typed body of type Req through the Client middleware chain
+// and returns a Response with the body deserialized into a value of type Resp.
+func Send[Req, Resp any](ctx context.Context, c *Client, method, url string, body Req, opts ...RequestOption) (*Response[Resp], error) {
+	return Do[Resp](ctx, c, method, url, body, opts...)
+}
+
+// GetAs is a typed shortcut for doing a GET request with Do.
+func GetAs[T any](ctx context.Context, c *Client, url string, opts ...RequestOption) (*Response[T], error) {
+	return Do[T](ctx, c, http.MethodGet, url, nil, opts...)
+}
+
+// HeadAs is a typed shortcut for doing a HEAD request with Do. The Value of
+// returned Response is always zero value, only status and headers are populated.
+func HeadAs(ctx context.Context, c *Client, url string, opts ...RequestOption) (*Response[struct{}], error) {
+	return Do[struct{}](ctx, c, http.MethodHead, url, nil, opts...)
+}
+
+// OptionsAs is a typed shortcut for doing an OPTIONS request with Do.
+func OptionsAs[T any](ctx context.Context, c *Client, url string, opts ...RequestOption) (*Response[T], error) {
+	return Do[T](ctx, c, http.MethodOptions, url, nil, opts...)
+}
+
+// PostAs is a typed shortcut for doing a POST request with Send.
+func PostAs[Req, Resp any](ctx context.Context, c *Client, url string, body Req, opts ...RequestOption) (*Response[Resp], error) {
+	return Send[Req, Resp](ctx, c, http.MethodPost, url, body, opts...)
+}
+
+// PutAs is a typed shortcut for doing a PUT request with Send.
+func PutAs[Req, Resp any](ctx context.Context, c *Client, url string, body Req, opts ...RequestOption) (*Response[Resp], error) {
+	return Send[Req, Resp](ctx, c, http.MethodPut, url, body, opts...)
+}
+
+// PatchAs is a typed shortcut for doing a PATCH request with Send.
+func PatchAs[Req, Resp any](ctx context.Context, c *Client, url string, body Req, opts ...RequestOption) (*Response[Resp], error) {
+	return Send[Req, Resp](ctx, c, http.MethodPatch, url, body, opts...)
+}
+
+// DeleteAs is a typed shortcut for doing a DELETE request with Do.
+func DeleteAs[T any](ctx context.Context, c *Client, url string, opts ...RequestOption) (*Response[T], error) {
+	return Do[T](ctx, c, http.MethodDelete, url, nil, opts...)
+}
+
+func readTypedResponse[T any](resp *http.Response) (*Response[T], error) {
+	defer DrainBody(resp.Body)
+	result := &Response[T]{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+	}
+	switch ClassifyResponse(resp) {
+	case StatusClassError:
+		return result, readErrorBody(NewHTTPError(resp), resp.Body)
+	case StatusClassEmptySuccess:
+		return result, nil
+	}
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return result, nil
+	}
+	body := bufio.NewReader(resp.Body)
+	if _, err := body.Peek(1); err == io.EOF {
+		return result, nil
+	}
+	if err := decodeBody(resp, body, &result.Value); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
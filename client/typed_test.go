@@ -0,0 +1,122 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+)
+
+type typedModel struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestTypedRequests(t *testing.T) {
+	url := "https://www.example.com/items"
+	mock := NewMockTransport(true)
+	for _, method := range []string{
+		http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions,
+	} {
+		mock.RegisterResponder(method, url, echoResponder)
+	}
+	mock.RegisterResponder(http.MethodHead, url,
+		func(request *http.Request) (*http.Response, error) {
+			header := make(http.Header)
+			header.Set("X-Method", request.Method)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       http.NoBody,
+				Header:     header,
+				Request:    request,
+			}, nil
+		})
+	richClient := NewClient(mock)
+	ctx := context.Background()
+
+	t.Run("Should decode typed response of GET request", func(t *testing.T) {
+		resp, err := GetAs[typedModel](ctx, richClient, url)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("got %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+		if resp.Value.Name != http.MethodGet {
+			t.Errorf("got %q, want %q", resp.Value.Name, http.MethodGet)
+		}
+		if resp.Header.Get("X-Method") != http.MethodGet {
+			t.Errorf("got header %q, want %q", resp.Header.Get("X-Method"), http.MethodGet)
+		}
+	})
+	t.Run("Should send typed body and decode typed response", func(t *testing.T) {
+		for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch} {
+			resp, err := Send[typedModel, typedModel](ctx, richClient, method, url, typedModel{ID: 7})
+			if err != nil {
+				t.Fatalf("did not expect an error but got one %v", err)
+			}
+			if resp.Value.ID != 7 || resp.Value.Name != method {
+				t.Errorf("got %+v for %s", resp.Value, method)
+			}
+		}
+	})
+	t.Run("Should support DELETE and OPTIONS requests", func(t *testing.T) {
+		for _, method := range []string{http.MethodDelete, http.MethodOptions} {
+			resp, err := Do[typedModel](ctx, richClient, method, url, nil)
+			if err != nil {
+				t.Fatalf("did not expect an error but got one %v", err)
+			}
+			if resp.Value.Name != method {
+				t.Errorf("got %q, want %q", resp.Value.Name, method)
+			}
+		}
+	})
+	t.Run("Should return only envelope of HEAD request", func(t *testing.T) {
+		resp, err := HeadAs(ctx, richClient, url)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if resp.Header.Get("X-Method") != http.MethodHead {
+			t.Errorf("got header %q, want %q", resp.Header.Get("X-Method"), http.MethodHead)
+		}
+	})
+	t.Run("Should apply client middleware", func(t *testing.T) {
+		c := NewClient(mock)
+		c.Use(createMiddleware(http.MethodGet, http.StatusConflict))
+		resp, err := GetAs[typedModel](ctx, c, url)
+		if err == nil {
+			t.Fatalf("should error")
+		}
+		if resp.StatusCode != http.StatusConflict {
+			t.Errorf("got %d, want %d", resp.StatusCode, http.StatusConflict)
+		}
+	})
+}
+
+// echoResponder responds with request body where name field is replaced with request method
+func echoResponder(request *http.Request) (*http.Response, error) {
+	body := []byte(`{}`)
+	if request.Body != nil {
+		b, err := io.ReadAll(request.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > 0 {
+			body = b
+		}
+	}
+	body = bytes.Replace(body, []byte(`"name":""`), []byte(`"name":"`+request.Method+`"`), 1)
+	if bytes.Equal(body, []byte(`{}`)) {
+		body = []byte(`{"name":"` + request.Method + `"}`)
+	}
+	header := make(http.Header)
+	header.Set("X-Method", request.Method)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Header:     header,
+		Request:    request,
+	}, nil
+}
@@ -0,0 +1,280 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/shuvava/go-enrichable-client/client"
+)
+
+/*
+Bulkhead is a middleware that limits the number of concurrent requests
+so a slow dependency cannot exhaust goroutines and connections.
+*/
+
+// BulkheadMiddlewareName is the name of Bulkhead middleware, see client.WithoutMiddleware
+const BulkheadMiddlewareName = "bulkhead"
+
+// ErrBulkheadFull is returned when the request cannot be started because the concurrency limit
+// is reached and the queue is full or the maximum wait time is exceeded.
+var ErrBulkheadFull = errors.New("bulkhead is full")
+
+// BulkheadConfig defines the config for Bulkhead middleware.
+//
+// MaxConcurrent is the maximum number of requests in flight. If MaxConcurrent is 0, the number is not limited.
+//
+// MaxConcurrentPerHost is the maximum number of requests in flight to a single host.
+// If MaxConcurrentPerHost is 0, the number is not limited.
+//
+// MaxQueue is the maximum number of requests waiting for a free slot.
+// If MaxQueue is 0, requests are rejected immediately when the limit is reached.
+//
+// MaxWait is the maximum time a request waits in the queue.
+// If MaxWait is 0, the request waits until the request context is done.
+//
+// KeyFunc returns the host key of the request. If KeyFunc is nil, the request URL host is used.
+// The state of a host is dropped when it has no requests in flight or queued.
+type BulkheadConfig struct {
+	MaxConcurrent        int
+	MaxConcurrentPerHost int
+	MaxQueue             int
+	MaxWait              time.Duration
+	KeyFunc              func(*http.Request) string
+}
+
+// BulkheadStats is a snapshot of BulkheadService utilization.
+type BulkheadStats struct {
+	// InFlight is the number of requests in flight
+	InFlight int
+	// Queued is the number of requests waiting for a free slot
+	Queued int
+	// MaxConcurrent is the configured limit of requests in flight, 0 if not limited
+	MaxConcurrent int
+	// Hosts is the number of requests in flight per host
+	Hosts map[string]int
+}
+
+// Utilization returns the ratio of requests in flight to MaxConcurrent,
+// or 0 if the number of requests is not limited.
+func (s BulkheadStats) Utilization() float64 {
+	if s.MaxConcurrent <= 0 {
+		return 0
+	}
+	return float64(s.InFlight) / float64(s.MaxConcurrent)
+}
+
+// BulkheadService limits concurrency of requests globally and per host.
+type BulkheadService struct {
+	config BulkheadConfig
+	global chan struct{}
+
+	mu       sync.Mutex
+	hosts    map[string]*hostSemaphore
+	inFlight map[string]int
+	queued   int
+}
+
+// NewBulkheadService returns a new BulkheadService configured with the given BulkheadConfig.
+func NewBulkheadService(cfg BulkheadConfig) *BulkheadService {
+	if cfg.KeyFunc == nil {
+		cfg.KeyFunc = func(r *http.Request) string { return r.URL.Host }
+	}
+	b := &BulkheadService{
+		config:   cfg,
+		hosts:    map[string]*hostSemaphore{},
+		inFlight: map[string]int{},
+	}
+	if cfg.MaxConcurrent > 0 {
+		b.global = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	return b
+}
+
+// WithoutBulkhead disables Bulkhead middleware for a single request.
+func WithoutBulkhead() client.RequestOption {
+	return client.WithoutMiddleware(BulkheadMiddlewareName)
+}
+
+// Stats returns the current utilization of the bulkhead.
+func (b *BulkheadService) Stats() BulkheadStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := BulkheadStats{
+		Queued:        b.queued,
+		MaxConcurrent: b.config.MaxConcurrent,
+		Hosts:         make(map[string]int, len(b.inFlight)),
+	}
+	for host, n := range b.inFlight {
+		stats.InFlight += n
+		stats.Hosts[host] = n
+	}
+	return stats
+}
+
+// hostSemaphore is the semaphore of a host shared by requests holding or waiting for its slots
+type hostSemaphore struct {
+	slots chan struct{}
+	refs  int
+}
+
+// hostSlots returns the semaphore of the host, it must be returned by putHostSlots
+func (b *BulkheadService) hostSlots(host string) chan struct{} {
+	if b.config.MaxConcurrentPerHost <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h, ok := b.hosts[host]
+	if !ok {
+		h = &hostSemaphore{slots: make(chan struct{}, b.config.MaxConcurrentPerHost)}
+		b.hosts[host] = h
+	}
+	h.refs++
+	return h.slots
+}
+
+// putHostSlots drops the semaphore of the host when no request holds or waits for its slots
+func (b *BulkheadService) putHostSlots(host string) {
+	if b.config.MaxConcurrentPerHost <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if h := b.hosts[host]; h != nil {
+		if h.refs--; h.refs <= 0 {
+			delete(b.hosts, host)
+		}
+	}
+}
+
+// tryAcquire takes a slot of every semaphore without waiting
+func tryAcquire(semaphores ...chan struct{}) bool {
+	for i, s := range semaphores {
+		if s == nil {
+			continue
+		}
+		select {
+		case s <- struct{}{}:
+		default:
+			release(semaphores[:i]...)
+			return false
+		}
+	}
+	return true
+}
+
+func release(semaphores ...chan struct{}) {
+	for _, s := range semaphores {
+		if s != nil {
+			<-s
+		}
+	}
+}
+
+// Acquire takes a slot for the request, waiting in the queue if needed.
+// The returned function releases the slot.
+func (b *BulkheadService) Acquire(request *http.Request) (func(), error) {
+	host := b.config.KeyFunc(request)
+	// the host slot is taken first, so requests waiting for a slow host do not hold global slots
+	semaphores := []chan struct{}{b.hostSlots(host), b.global}
+	if !tryAcquire(semaphores...) {
+		if err := b.wait(request, host, semaphores); err != nil {
+			b.putHostSlots(host)
+			return nil, err
+		}
+	}
+	b.mu.Lock()
+	b.inFlight[host]++
+	b.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			if b.inFlight[host]--; b.inFlight[host] <= 0 {
+				delete(b.inFlight, host)
+			}
+			b.mu.Unlock()
+			release(semaphores...)
+			b.putHostSlots(host)
+		})
+	}, nil
+}
+
+// wait queues the request until all semaphores are acquired
+func (b *BulkheadService) wait(request *http.Request, host string, semaphores []chan struct{}) error {
+	b.mu.Lock()
+	if b.queued >= b.config.MaxQueue {
+		b.mu.Unlock()
+		return fmt.Errorf("%w: %q", ErrBulkheadFull, host)
+	}
+	b.queued++
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.queued--
+		b.mu.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if b.config.MaxWait > 0 {
+		timer := time.NewTimer(b.config.MaxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	ctx := request.Context()
+	for i, s := range semaphores {
+		if s == nil {
+			continue
+		}
+		select {
+		case s <- struct{}{}:
+		case <-ctx.Done():
+			release(semaphores[:i]...)
+			return ctx.Err()
+		case <-timeout:
+			release(semaphores[:i]...)
+			return fmt.Errorf("%w: %q, waited %s", ErrBulkheadFull, host, b.config.MaxWait)
+		}
+	}
+	return nil
+}
+
+// releaseBody releases the bulkhead slot when the response body is closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// Execute process http.Client Do operation.
+// The slot is released when the response body is closed.
+func (b *BulkheadService) Execute(_ *http.Client, next client.Responder) client.Responder {
+	return func(request *http.Request) (*http.Response, error) {
+		done, err := b.Acquire(request)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := next(request)
+		if resp == nil || resp.Body == nil {
+			done()
+			return resp, err
+		}
+		resp.Body = &releaseBody{ReadCloser: resp.Body, release: done}
+		return resp, err
+	}
+}
+
+// Bulkhead adds Bulkhead middleware to requests
+func Bulkhead(cfg BulkheadConfig) client.MiddlewareFunc {
+	b := NewBulkheadService(cfg)
+	return client.Named(BulkheadMiddlewareName, b.Execute)
+}
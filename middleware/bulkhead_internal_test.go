@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBulkheadHostEviction(t *testing.T) {
+	t.Run("Should drop hosts without requests", func(t *testing.T) {
+		b := NewBulkheadService(BulkheadConfig{MaxConcurrentPerHost: 1})
+		acquire := func(url string) (func(), error) {
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			return b.Acquire(req)
+		}
+		releaseA, errA := acquire("https://a.example.com")
+		releaseB, errB := acquire("https://b.example.com")
+		if errA != nil || errB != nil {
+			t.Fatalf("did not expect an error but got one %v %v", errA, errB)
+		}
+		if _, err := acquire("https://a.example.com"); err == nil {
+			t.Errorf("error should be returned")
+		}
+		if len(b.hosts) != 2 {
+			t.Errorf("hosts got %d, expected %d", len(b.hosts), 2)
+		}
+		releaseA()
+		if _, ok := b.hosts["a.example.com"]; ok || len(b.hosts) != 1 {
+			t.Errorf("hosts got %d, expected %d", len(b.hosts), 1)
+		}
+		releaseB()
+		if len(b.hosts) != 0 {
+			t.Errorf("hosts got %d, expected %d", len(b.hosts), 0)
+		}
+	})
+	t.Run("Should keep hosts with queued requests", func(t *testing.T) {
+		b := NewBulkheadService(BulkheadConfig{MaxConcurrentPerHost: 1, MaxQueue: 1})
+		req, _ := http.NewRequest(http.MethodGet, "https://a.example.com", nil)
+		release, err := b.Acquire(req)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		acquired := make(chan func())
+		go func() {
+			next, _ := b.Acquire(req)
+			acquired <- next
+		}()
+		for deadline := time.Now().Add(time.Second); b.Stats().Queued == 0 && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		release()
+		next := <-acquired
+		if next == nil {
+			t.Fatalf("queued request should acquire the slot")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := b.Acquire(req.WithContext(ctx)); err == nil {
+			t.Errorf("host limit should be kept for the queued request")
+		}
+		next()
+		if len(b.hosts) != 0 {
+			t.Errorf("hosts got %d, expected %d", len(b.hosts), 0)
+		}
+	})
+}
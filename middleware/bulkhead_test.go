@@ -0,0 +1,126 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shuvava/go-enrichable-client/client"
+	"github.com/shuvava/go-enrichable-client/middleware"
+)
+
+func createBulkheadClient(cfg middleware.BulkheadConfig, urls ...string) (*http.Client, *middleware.BulkheadService) {
+	mock := client.NewMockTransport(true)
+	for _, url := range urls {
+		mock.RegisterResponder(http.MethodGet, url, func(request *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString("ok")),
+				Header:     make(http.Header),
+			}, nil
+		})
+	}
+	b := middleware.NewBulkheadService(cfg)
+	richClient := client.NewClient(mock)
+	richClient.Use(client.Named(middleware.BulkheadMiddlewareName, b.Execute))
+	return richClient.Client, b
+}
+
+func TestBulkheadMiddleware(t *testing.T) {
+	t.Run("Should reject requests over the limit", func(t *testing.T) {
+		url := "https://www.example.com"
+		c, b := createBulkheadClient(middleware.BulkheadConfig{MaxConcurrent: 1}, url)
+
+		// the slot is held until the response body is closed
+		first, err := c.Get(url)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if stats := b.Stats(); stats.InFlight != 1 || stats.Utilization() != 1 {
+			t.Errorf("stats got %+v", stats)
+		}
+		if _, err = c.Get(url); !errors.Is(err, middleware.ErrBulkheadFull) {
+			t.Fatalf("expected ErrBulkheadFull but got %v", err)
+		}
+		_ = first.Body.Close()
+		if stats := b.Stats(); stats.InFlight != 0 {
+			t.Errorf("in flight got %d, want %d", stats.InFlight, 0)
+		}
+		response, err := c.Get(url)
+		assertResponse(t, response, err, http.StatusOK, "ok")
+	})
+	t.Run("Should limit hosts separately", func(t *testing.T) {
+		urlA, urlB := "https://a.example.com", "https://b.example.com"
+		c, b := createBulkheadClient(middleware.BulkheadConfig{MaxConcurrentPerHost: 1}, urlA, urlB)
+
+		first, err := c.Get(urlA)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		defer first.Body.Close()
+		if _, err = c.Get(urlA); !errors.Is(err, middleware.ErrBulkheadFull) {
+			t.Fatalf("expected ErrBulkheadFull but got %v", err)
+		}
+		second, err := c.Get(urlB)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		defer second.Body.Close()
+		if stats := b.Stats(); stats.Hosts["a.example.com"] != 1 || stats.Hosts["b.example.com"] != 1 {
+			t.Errorf("hosts got %v", stats.Hosts)
+		}
+	})
+	t.Run("Should wait in the queue", func(t *testing.T) {
+		url := "https://www.example.com"
+		c, b := createBulkheadClient(middleware.BulkheadConfig{MaxConcurrent: 1, MaxQueue: 1, MaxWait: time.Second}, url)
+
+		first, err := c.Get(url)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		go func() {
+			for b.Stats().Queued == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			_ = first.Body.Close()
+		}()
+		response, err := c.Get(url)
+		assertResponse(t, response, err, http.StatusOK, "ok")
+	})
+	t.Run("Should stop waiting after MaxWait", func(t *testing.T) {
+		url := "https://www.example.com"
+		c, _ := createBulkheadClient(middleware.BulkheadConfig{MaxConcurrent: 1, MaxQueue: 1, MaxWait: 10 * time.Millisecond}, url)
+
+		first, err := c.Get(url)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		defer first.Body.Close()
+		if _, err = c.Get(url); !errors.Is(err, middleware.ErrBulkheadFull) {
+			t.Errorf("expected ErrBulkheadFull but got %v", err)
+		}
+	})
+	t.Run("Should stop waiting on context cancellation", func(t *testing.T) {
+		url := "https://www.example.com"
+		c, b := createBulkheadClient(middleware.BulkheadConfig{MaxConcurrent: 1, MaxQueue: 1}, url)
+
+		first, err := c.Get(url)
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		defer first.Body.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if _, err = c.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded but got %v", err)
+		}
+		if stats := b.Stats(); stats.Queued != 0 || stats.InFlight != 1 {
+			t.Errorf("stats got %+v", stats)
+		}
+	})
+}
@@ -0,0 +1,472 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shuvava/go-enrichable-client/client"
+)
+
+/*
+Cache is a middleware implementing a private HTTP cache (RFC 9111).
+*/
+
+const (
+	// CacheMiddlewareName is the name of Cache middleware, see client.WithoutMiddleware
+	CacheMiddlewareName = "cache"
+
+	// CacheStatusHeader is the response header set by Cache middleware to
+	// CacheStatusHit, CacheStatusMiss or CacheStatusRevalidated
+	CacheStatusHeader = "X-Cache-Status"
+	// CacheStatusHit means the response is served from the cache without a request
+	CacheStatusHit = "HIT"
+	// CacheStatusMiss means the response is received from the server
+	CacheStatusMiss = "MISS"
+	// CacheStatusRevalidated means the cached response is validated by the server with 304 Not Modified
+	CacheStatusRevalidated = "REVALIDATED"
+
+	defaultCacheMaxEntrySize = 1 << 20
+	defaultCacheEntries      = 1000
+	// heuristicFraction of the time since the last modification is used as freshness lifetime
+	// of responses without explicit expiration (RFC 9111 section 4.2.2)
+	heuristicFraction    = 10
+	maxHeuristicLifetime = 24 * time.Hour
+)
+
+// CacheConfig defines the config for Cache middleware.
+//
+// Store keeps cached responses. If Store is nil, NewMemoryCacheStore(1000, 0) is used.
+//
+// KeyFunc returns the cache key of the request. If KeyFunc is nil, the request URL is used.
+// The cache is private: use KeyFunc to separate responses of different users sharing the client.
+//
+// MaxEntrySize is the maximum size of the cached response body. Larger responses are not cached.
+// If MaxEntrySize is 0, 1MB is used.
+type CacheConfig struct {
+	Store        CacheStore
+	KeyFunc      func(*http.Request) string
+	MaxEntrySize int64
+}
+
+// cacheEntry is a cached response
+type cacheEntry struct {
+	// Response is the response in HTTP/1.x wire format
+	Response []byte `json:"response"`
+	// RequestTime is the time the request was sent
+	RequestTime time.Time `json:"request_time"`
+	// ResponseTime is the time the response was received
+	ResponseTime time.Time `json:"response_time"`
+	// Vary are the request header values selected by Vary response header
+	Vary map[string]string `json:"vary,omitempty"`
+}
+
+// CacheService is a private HTTP cache.
+type CacheService struct {
+	store        CacheStore
+	keyFunc      func(*http.Request) string
+	maxEntrySize int64
+}
+
+// NewCacheService returns a new CacheService configured with the given CacheConfig.
+func NewCacheService(cfg CacheConfig) *CacheService {
+	s := &CacheService{
+		store:        cfg.Store,
+		keyFunc:      cfg.KeyFunc,
+		maxEntrySize: cfg.MaxEntrySize,
+	}
+	if s.store == nil {
+		s.store = NewMemoryCacheStore(defaultCacheEntries, 0)
+	}
+	if s.keyFunc == nil {
+		s.keyFunc = func(r *http.Request) string { return r.URL.String() }
+	}
+	if s.maxEntrySize <= 0 {
+		s.maxEntrySize = defaultCacheMaxEntrySize
+	}
+	return s
+}
+
+// WithoutCache disables Cache middleware for a single request.
+func WithoutCache() client.RequestOption {
+	return client.WithoutMiddleware(CacheMiddlewareName)
+}
+
+// cacheControl is parsed Cache-Control header
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, line := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(part, "=")
+			cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds returns the value of the directive in seconds
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	v, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// cacheableByDefault are status codes heuristically cacheable (RFC 9110 section 15.1)
+var cacheableByDefault = map[int]bool{
+	http.StatusOK: true, http.StatusNonAuthoritativeInfo: true, http.StatusNoContent: true,
+	http.StatusMultipleChoices: true, http.StatusMovedPermanently: true, http.StatusPermanentRedirect: true,
+	http.StatusNotFound: true, http.StatusMethodNotAllowed: true, http.StatusGone: true,
+	http.StatusRequestURITooLong: true, http.StatusNotImplemented: true,
+}
+
+// storable reports whether the response can be stored (RFC 9111 section 3)
+func storable(req *http.Request, resp *http.Response) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	reqCC, respCC := parseCacheControl(req.Header), parseCacheControl(resp.Header)
+	if reqCC.has("no-store") || respCC.has("no-store") {
+		return false
+	}
+	if strings.TrimSpace(resp.Header.Get("Vary")) == "*" {
+		return false
+	}
+	// partial content is not the full representation to be served for the request (RFC 9111 section 3.3)
+	if resp.StatusCode == http.StatusPartialContent || resp.Header.Get("Content-Range") != "" {
+		return false
+	}
+	if cacheableByDefault[resp.StatusCode] {
+		return true
+	}
+	// other status codes require explicit freshness
+	_, hasMaxAge := respCC.seconds("max-age")
+	return resp.StatusCode >= 200 && (hasMaxAge || respCC.has("public") || resp.Header.Get("Expires") != "")
+}
+
+// httpDate parses the date header value
+func httpDate(h http.Header, name string) (time.Time, bool) {
+	v := h.Get(name)
+	if v == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(v)
+	return t, err == nil
+}
+
+// freshnessLifetime calculates the freshness lifetime of the response (RFC 9111 section 4.2.1)
+func freshnessLifetime(resp *http.Response) time.Duration {
+	cc := parseCacheControl(resp.Header)
+	if maxAge, ok := cc.seconds("max-age"); ok {
+		return maxAge
+	}
+	date, hasDate := httpDate(resp.Header, "Date")
+	if resp.Header.Get("Expires") != "" {
+		expires, ok := httpDate(resp.Header, "Expires")
+		if !ok || !hasDate {
+			// invalid Expires means already expired
+			return 0
+		}
+		if lifetime := expires.Sub(date); lifetime > 0 {
+			return lifetime
+		}
+		return 0
+	}
+	if lastModified, ok := httpDate(resp.Header, "Last-Modified"); ok && hasDate && cacheableByDefault[resp.StatusCode] {
+		lifetime := date.Sub(lastModified) / heuristicFraction
+		if lifetime > maxHeuristicLifetime {
+			lifetime = maxHeuristicLifetime
+		}
+		if lifetime > 0 {
+			return lifetime
+		}
+	}
+	return 0
+}
+
+// currentAge calculates the age of the cached response (RFC 9111 section 4.2.3)
+func (e *cacheEntry) currentAge(resp *http.Response, now time.Time) time.Duration {
+	apparentAge := time.Duration(0)
+	if date, ok := httpDate(resp.Header, "Date"); ok {
+		if d := e.ResponseTime.Sub(date); d > 0 {
+			apparentAge = d
+		}
+	}
+	ageValue := time.Duration(0)
+	if n, err := strconv.ParseInt(resp.Header.Get("Age"), 10, 64); err == nil && n > 0 {
+		ageValue = time.Duration(n) * time.Second
+	}
+	correctedAge := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	if correctedAge < apparentAge {
+		correctedAge = apparentAge
+	}
+	return correctedAge + now.Sub(e.ResponseTime)
+}
+
+// fresh reports whether the cached response can be served without validation (RFC 9111 section 4.2)
+func fresh(req *http.Request, resp *http.Response, age time.Duration) bool {
+	reqCC, respCC := parseCacheControl(req.Header), parseCacheControl(resp.Header)
+	if reqCC.has("no-cache") || respCC.has("no-cache") || req.Header.Get("Pragma") == "no-cache" {
+		return false
+	}
+	lifetime := freshnessLifetime(resp)
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok {
+		age += minFresh
+	}
+	if age < lifetime {
+		return true
+	}
+	if respCC.has("must-revalidate") {
+		return false
+	}
+	if reqCC.has("max-stale") {
+		maxStale, ok := reqCC.seconds("max-stale")
+		// max-stale without value accepts any stale response
+		return !ok || age-lifetime <= maxStale
+	}
+	return false
+}
+
+// varyMatches reports whether the request matches the request the entry is stored for (RFC 9111 section 4.1)
+func (e *cacheEntry) varyMatches(req *http.Request) bool {
+	for name, value := range e.Vary {
+		if strings.Join(req.Header.Values(name), ", ") != value {
+			return false
+		}
+	}
+	return true
+}
+
+func varyValues(req *http.Request, resp *http.Response) map[string]string {
+	var values map[string]string
+	for _, line := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if values == nil {
+				values = map[string]string{}
+			}
+			values[name] = strings.Join(req.Header.Values(name), ", ")
+		}
+	}
+	return values
+}
+
+// load returns the cached entry and its response matching the request
+func (s *CacheService) load(key string, req *http.Request) (*cacheEntry, *http.Response) {
+	data, ok, err := s.store.Get(key)
+	if err != nil || !ok {
+		return nil, nil
+	}
+	var e cacheEntry
+	if err = json.Unmarshal(data, &e); err != nil || !e.varyMatches(req) {
+		return nil, nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(e.Response)), req)
+	if err != nil {
+		return nil, nil
+	}
+	return &e, resp
+}
+
+// save stores the response with the body
+func (s *CacheService) save(key string, req *http.Request, resp *http.Response, body []byte, requestTime, responseTime time.Time) {
+	stored := *resp
+	stored.Body = io.NopCloser(bytes.NewReader(body))
+	stored.ContentLength = int64(len(body))
+	stored.TransferEncoding = nil
+	stored.Close = false
+	stored.Header = resp.Header.Clone()
+	stored.Header.Del(CacheStatusHeader)
+	var buf bytes.Buffer
+	if err := stored.Write(&buf); err != nil {
+		return
+	}
+	data, err := json.Marshal(&cacheEntry{
+		Response:     buf.Bytes(),
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+		Vary:         varyValues(req, resp),
+	})
+	if err != nil {
+		return
+	}
+	_ = s.store.Set(key, data)
+}
+
+// readBody reads the response body up to the maximum entry size.
+// It returns false with the response body restored if the body is larger.
+func (s *CacheService) readBody(resp *http.Response) ([]byte, bool, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, s.maxEntrySize+1))
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, false, err
+	}
+	if int64(len(body)) > s.maxEntrySize {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil, false, nil
+	}
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true, nil
+}
+
+// storeResponse caches the response if it is storable and returns the response with a readable body
+func (s *CacheService) storeResponse(key string, req *http.Request, resp *http.Response, requestTime time.Time) (*http.Response, error) {
+	if !storable(req, resp) {
+		return resp, nil
+	}
+	body, ok, err := s.readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		s.save(key, req, resp, body, requestTime, time.Now())
+	}
+	return resp, nil
+}
+
+// cachedResponse prepares the cached response to be returned to the caller
+func cachedResponse(req *http.Request, resp *http.Response, age time.Duration, status string) *http.Response {
+	resp.Request = req
+	resp.Header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	resp.Header.Set(CacheStatusHeader, status)
+	return resp
+}
+
+// updateHeaders updates stored headers with headers of 304 response (RFC 9111 section 4.3.4)
+func updateHeaders(stored, notModified http.Header) {
+	for name, values := range notModified {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Content-Range":
+			continue
+		}
+		stored[name] = values
+	}
+}
+
+// gatewayTimeout is returned for only-if-cached requests without cached response (RFC 9111 section 5.2.1.7)
+func gatewayTimeout(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     "504 Gateway Timeout",
+		StatusCode: http.StatusGatewayTimeout,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{CacheStatusHeader: []string{CacheStatusMiss}},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+// invalidates reports whether the request invalidates cached responses of the URL (RFC 9111 section 4.4)
+func invalidates(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// Execute process http.Client Do operation
+func (s *CacheService) Execute(_ *http.Client, next client.Responder) client.Responder {
+	return func(request *http.Request) (*http.Response, error) {
+		if request.Method != http.MethodGet {
+			resp, err := next(request)
+			if err == nil && invalidates(request) && client.ClassifyResponse(resp) != client.StatusClassError {
+				_ = s.store.Delete(s.keyFunc(request))
+			}
+			return resp, err
+		}
+		// conditional requests of the caller are not served from the cache
+		if request.Header.Get("If-None-Match") != "" || request.Header.Get("If-Modified-Since") != "" {
+			return next(request)
+		}
+		key := s.keyFunc(request)
+		entry, cached := s.load(key, request)
+		now := time.Now()
+		if cached != nil {
+			age := entry.currentAge(cached, now)
+			if fresh(request, cached, age) {
+				return cachedResponse(request, cached, age, CacheStatusHit), nil
+			}
+		} else if parseCacheControl(request.Header).has("only-if-cached") {
+			return gatewayTimeout(request), nil
+		}
+
+		req := request
+		if cached != nil {
+			etag, lastModified := cached.Header.Get("ETag"), cached.Header.Get("Last-Modified")
+			if etag != "" || lastModified != "" {
+				req = request.Clone(request.Context())
+				if etag != "" {
+					req.Header.Set("If-None-Match", etag)
+				}
+				if lastModified != "" {
+					req.Header.Set("If-Modified-Since", lastModified)
+				}
+			}
+		}
+		resp, err := next(req)
+		if err != nil {
+			if cached != nil {
+				_ = cached.Body.Close()
+			}
+			return resp, err
+		}
+		if cached != nil && req != request && resp.StatusCode == http.StatusNotModified {
+			client.DrainBody(resp.Body)
+			updateHeaders(cached.Header, resp.Header)
+			body, _, err := s.readBody(cached)
+			if err != nil {
+				return nil, err
+			}
+			s.save(key, request, cached, body, now, time.Now())
+			return cachedResponse(request, cached, 0, CacheStatusRevalidated), nil
+		}
+		if cached != nil {
+			_ = cached.Body.Close()
+		}
+		if resp.Header == nil {
+			resp.Header = http.Header{}
+		}
+		resp.Header.Set(CacheStatusHeader, CacheStatusMiss)
+		return s.storeResponse(key, request, resp, now)
+	}
+}
+
+// Cache adds private HTTP cache middleware to requests.
+// Place it before Retry middleware to serve cached responses without retries.
+func Cache(cfg CacheConfig) client.MiddlewareFunc {
+	s := NewCacheService(cfg)
+	return client.Named(CacheMiddlewareName, s.Execute)
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CacheStore is a storage of cached responses used by Cache middleware.
+// Implementations must be safe for concurrent use.
+type CacheStore interface {
+	// Get returns the value stored by the key and reports whether it exists
+	Get(key string) ([]byte, bool, error)
+	// Set stores the value by the key
+	Set(key string, value []byte) error
+	// Delete removes the value stored by the key
+	Delete(key string) error
+}
+
+// MemoryCacheStore is an in-memory CacheStore evicting the least recently used entries.
+type MemoryCacheStore struct {
+	maxEntries int
+	maxBytes   int64
+
+	mu    sync.Mutex
+	size  int64
+	order *list.List
+	items map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	value []byte
+}
+
+// NewMemoryCacheStore creates MemoryCacheStore keeping at most maxEntries entries
+// with total size of values at most maxBytes. Zero means no limit.
+func NewMemoryCacheStore(maxEntries int, maxBytes int64) *MemoryCacheStore {
+	return &MemoryCacheStore{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		order:      list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+// Get implements CacheStore interface.
+func (s *MemoryCacheStore) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	s.order.MoveToFront(e)
+	return e.Value.(*memoryCacheItem).value, true, nil
+}
+
+// Set implements CacheStore interface.
+func (s *MemoryCacheStore) Set(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxBytes > 0 && int64(len(value)) > s.maxBytes {
+		s.remove(key)
+		return nil
+	}
+	if e, ok := s.items[key]; ok {
+		item := e.Value.(*memoryCacheItem)
+		s.size += int64(len(value) - len(item.value))
+		item.value = value
+		s.order.MoveToFront(e)
+	} else {
+		s.items[key] = s.order.PushFront(&memoryCacheItem{key: key, value: value})
+		s.size += int64(len(value))
+	}
+	for (s.maxEntries > 0 && s.order.Len() > s.maxEntries) || (s.maxBytes > 0 && s.size > s.maxBytes) {
+		s.remove(s.order.Back().Value.(*memoryCacheItem).key)
+	}
+	return nil
+}
+
+// Delete implements CacheStore interface.
+func (s *MemoryCacheStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+	return nil
+}
+
+// Len returns the number of entries in the store.
+func (s *MemoryCacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *MemoryCacheStore) remove(key string) {
+	e, ok := s.items[key]
+	if !ok {
+		return
+	}
+	s.size -= int64(len(e.Value.(*memoryCacheItem).value))
+	s.order.Remove(e)
+	delete(s.items, key)
+}
+
+// DiskCacheStore is a CacheStore keeping entries in files of a directory.
+type DiskCacheStore struct {
+	dir string
+}
+
+// NewDiskCacheStore creates DiskCacheStore in the directory, creating it if needed.
+func NewDiskCacheStore(dir string) (*DiskCacheStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &DiskCacheStore{dir: dir}, nil
+}
+
+// path returns the file name of the key
+func (s *DiskCacheStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get implements CacheStore interface.
+func (s *DiskCacheStore) Get(key string) ([]byte, bool, error) {
+	value, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Set implements CacheStore interface. The file is replaced atomically.
+func (s *DiskCacheStore) Set(key string, value []byte) error {
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path(key))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// Delete implements CacheStore interface.
+func (s *DiskCacheStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
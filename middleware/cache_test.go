@@ -0,0 +1,288 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shuvava/go-enrichable-client/client"
+	"github.com/shuvava/go-enrichable-client/middleware"
+)
+
+type cacheServerMock struct {
+	calls   int
+	request *http.Request
+	handler func(request *http.Request) (int, http.Header, string)
+}
+
+func createCacheClient(url string, cfg middleware.CacheConfig, handler func(request *http.Request) (int, http.Header, string)) (*http.Client, *cacheServerMock) {
+	m := &cacheServerMock{handler: handler}
+	mock := client.NewMockTransport(true)
+	responder := func(request *http.Request) (*http.Response, error) {
+		m.calls++
+		m.request = request
+		code, header, body := m.handler(request)
+		if header.Get("Date") == "" {
+			header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		}
+		return &http.Response{
+			StatusCode: code,
+			Body:       io.NopCloser(bytes.NewBufferString(body)),
+			Header:     header,
+		}, nil
+	}
+	mock.RegisterResponder(http.MethodGet, url, responder)
+	mock.RegisterResponder(http.MethodPost, url, responder)
+	richClient := client.NewClient(mock)
+	richClient.Use(middleware.Cache(cfg))
+	return richClient.Client, m
+}
+
+func assertCacheStatus(t *testing.T, response *http.Response, want string) {
+	t.Helper()
+	if got := response.Header.Get(middleware.CacheStatusHeader); got != want {
+		t.Errorf("cache status got %q, want %q", got, want)
+	}
+}
+
+func TestCacheMiddleware(t *testing.T) {
+	t.Run("Should serve fresh response from cache", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com/items"
+			wantStatusCode = http.StatusOK
+			wantBody       = `[1,2,3]`
+		)
+		c, m := createCacheClient(url, middleware.CacheConfig{}, func(*http.Request) (int, http.Header, string) {
+			return wantStatusCode, http.Header{"Cache-Control": {"max-age=60"}}, wantBody
+		})
+
+		response, err := c.Get(url)
+		assertCacheStatus(t, response, middleware.CacheStatusMiss)
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+		response, err = c.Get(url)
+		assertCacheStatus(t, response, middleware.CacheStatusHit)
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+		if m.calls != 1 {
+			t.Errorf("calls got %d, expected %d", m.calls, 1)
+		}
+	})
+	t.Run("Should not cache no-store response", func(t *testing.T) {
+		url := "https://www.example.com/items"
+		c, m := createCacheClient(url, middleware.CacheConfig{}, func(*http.Request) (int, http.Header, string) {
+			return http.StatusOK, http.Header{"Cache-Control": {"no-store"}}, "ok"
+		})
+
+		for i := 0; i < 2; i++ {
+			response, err := c.Get(url)
+			assertResponse(t, response, err, http.StatusOK, "ok")
+		}
+		if m.calls != 2 {
+			t.Errorf("calls got %d, expected %d", m.calls, 2)
+		}
+	})
+	t.Run("Should not cache partial content", func(t *testing.T) {
+		url := "https://www.example.com/items"
+		c, m := createCacheClient(url, middleware.CacheConfig{}, func(*http.Request) (int, http.Header, string) {
+			return http.StatusPartialContent, http.Header{"Cache-Control": {"max-age=60"}, "Content-Range": {"bytes 0-1/7"}}, "[1"
+		})
+
+		for i := 0; i < 2; i++ {
+			response, err := c.Get(url)
+			assertResponse(t, response, err, http.StatusPartialContent, "[1")
+		}
+		if m.calls != 2 {
+			t.Errorf("calls got %d, expected %d", m.calls, 2)
+		}
+	})
+	t.Run("Should revalidate stale response with ETag", func(t *testing.T) {
+		var (
+			url      = "https://www.example.com/items"
+			wantBody = `[1,2,3]`
+			etag     = `"v1"`
+		)
+		c, m := createCacheClient(url, middleware.CacheConfig{}, func(request *http.Request) (int, http.Header, string) {
+			header := http.Header{"Cache-Control": {"max-age=0"}, "Etag": {etag}}
+			if request.Header.Get("If-None-Match") == etag {
+				return http.StatusNotModified, header, ""
+			}
+			return http.StatusOK, header, wantBody
+		})
+
+		response, err := c.Get(url)
+		assertResponse(t, response, err, http.StatusOK, wantBody)
+		response, err = c.Get(url)
+		assertCacheStatus(t, response, middleware.CacheStatusRevalidated)
+		assertResponse(t, response, err, http.StatusOK, wantBody)
+		if m.calls != 2 {
+			t.Errorf("calls got %d, expected %d", m.calls, 2)
+		}
+		if got := m.request.Header.Get("If-None-Match"); got != etag {
+			t.Errorf("If-None-Match got %q, want %q", got, etag)
+		}
+	})
+	t.Run("Should revalidate with Last-Modified", func(t *testing.T) {
+		var (
+			url          = "https://www.example.com/items"
+			lastModified = time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+		)
+		c, m := createCacheClient(url, middleware.CacheConfig{}, func(request *http.Request) (int, http.Header, string) {
+			header := http.Header{"Cache-Control": {"no-cache"}, "Last-Modified": {lastModified}}
+			if request.Header.Get("If-Modified-Since") == lastModified {
+				return http.StatusNotModified, header, ""
+			}
+			return http.StatusOK, header, "ok"
+		})
+
+		for i := 0; i < 2; i++ {
+			response, err := c.Get(url)
+			assertResponse(t, response, err, http.StatusOK, "ok")
+		}
+		if got := m.request.Header.Get("If-Modified-Since"); got != lastModified {
+			t.Errorf("If-Modified-Since got %q, want %q", got, lastModified)
+		}
+	})
+	t.Run("Should respect Vary", func(t *testing.T) {
+		url := "https://www.example.com/items"
+		c, m := createCacheClient(url, middleware.CacheConfig{}, func(request *http.Request) (int, http.Header, string) {
+			header := http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}}
+			return http.StatusOK, header, request.Header.Get("Accept-Language")
+		})
+
+		for _, lang := range []string{"en", "en", "de"} {
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			req.Header.Set("Accept-Language", lang)
+			response, err := c.Do(req)
+			assertResponse(t, response, err, http.StatusOK, lang)
+		}
+		if m.calls != 2 {
+			t.Errorf("calls got %d, expected %d", m.calls, 2)
+		}
+	})
+	t.Run("Should honor request Cache-Control", func(t *testing.T) {
+		url := "https://www.example.com/items"
+		c, m := createCacheClient(url, middleware.CacheConfig{}, func(*http.Request) (int, http.Header, string) {
+			return http.StatusOK, http.Header{"Expires": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}, "ok"
+		})
+
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Cache-Control", "only-if-cached")
+		response, err := c.Do(req)
+		if err != nil || response.StatusCode != http.StatusGatewayTimeout {
+			t.Fatalf("expected 504 for only-if-cached but got %v %v", response, err)
+		}
+		response, err = c.Get(url)
+		assertResponse(t, response, err, http.StatusOK, "ok")
+		req.Header.Set("Cache-Control", "no-cache")
+		response, err = c.Do(req)
+		assertCacheStatus(t, response, middleware.CacheStatusMiss)
+		assertResponse(t, response, err, http.StatusOK, "ok")
+		if m.calls != 2 {
+			t.Errorf("calls got %d, expected %d", m.calls, 2)
+		}
+	})
+	t.Run("Should invalidate cache on unsafe request", func(t *testing.T) {
+		url := "https://www.example.com/items"
+		c, m := createCacheClient(url, middleware.CacheConfig{}, func(*http.Request) (int, http.Header, string) {
+			return http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}, "ok"
+		})
+
+		response, err := c.Get(url)
+		assertResponse(t, response, err, http.StatusOK, "ok")
+		response, err = c.Post(url, client.ContentTypeJSON, bytes.NewBufferString("{}"))
+		assertResponse(t, response, err, http.StatusOK, "ok")
+		response, err = c.Get(url)
+		assertCacheStatus(t, response, middleware.CacheStatusMiss)
+		assertResponse(t, response, err, http.StatusOK, "ok")
+		if m.calls != 3 {
+			t.Errorf("calls got %d, expected %d", m.calls, 3)
+		}
+	})
+	t.Run("Should not cache large responses", func(t *testing.T) {
+		var (
+			url      = "https://www.example.com/items"
+			wantBody = "0123456789"
+		)
+		c, m := createCacheClient(url, middleware.CacheConfig{MaxEntrySize: 5}, func(*http.Request) (int, http.Header, string) {
+			return http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}, wantBody
+		})
+
+		for i := 0; i < 2; i++ {
+			response, err := c.Get(url)
+			assertResponse(t, response, err, http.StatusOK, wantBody)
+		}
+		if m.calls != 2 {
+			t.Errorf("calls got %d, expected %d", m.calls, 2)
+		}
+	})
+	t.Run("Should be disabled by request option", func(t *testing.T) {
+		url := "https://www.example.com/items"
+		c, m := createCacheClient(url, middleware.CacheConfig{}, func(*http.Request) (int, http.Header, string) {
+			return http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}, "ok"
+		})
+
+		for i := 0; i < 2; i++ {
+			req, _ := client.NewRequest(context.Background(), http.MethodGet, url, nil, middleware.WithoutCache())
+			response, err := c.Do(req.Request)
+			assertResponse(t, response, err, http.StatusOK, "ok")
+		}
+		if m.calls != 2 {
+			t.Errorf("calls got %d, expected %d", m.calls, 2)
+		}
+	})
+}
+
+func TestCacheStore(t *testing.T) {
+	t.Run("Should evict least recently used entries", func(t *testing.T) {
+		s := middleware.NewMemoryCacheStore(2, 0)
+		_ = s.Set("a", []byte("1"))
+		_ = s.Set("b", []byte("2"))
+		_, _, _ = s.Get("a")
+		_ = s.Set("c", []byte("3"))
+		if _, ok, _ := s.Get("b"); ok {
+			t.Error("expected b to be evicted")
+		}
+		if _, ok, _ := s.Get("a"); !ok {
+			t.Error("expected a to be kept")
+		}
+		if s.Len() != 2 {
+			t.Errorf("len got %d, want %d", s.Len(), 2)
+		}
+	})
+	t.Run("Should store entries on disk", func(t *testing.T) {
+		s, err := middleware.NewDiskCacheStore(t.TempDir())
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if err = s.Set("https://www.example.com/items", []byte("value")); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		value, ok, err := s.Get("https://www.example.com/items")
+		if err != nil || !ok || string(value) != "value" {
+			t.Errorf("got %q %v %v", value, ok, err)
+		}
+		_ = s.Delete("https://www.example.com/items")
+		if _, ok, _ = s.Get("https://www.example.com/items"); ok {
+			t.Error("expected entry to be deleted")
+		}
+	})
+	t.Run("Should serve cached response from disk", func(t *testing.T) {
+		var (
+			url      = "https://www.example.com/items"
+			wantBody = `[1,2,3]`
+		)
+		store, _ := middleware.NewDiskCacheStore(t.TempDir())
+		c, m := createCacheClient(url, middleware.CacheConfig{Store: store}, func(*http.Request) (int, http.Header, string) {
+			return http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}, wantBody
+		})
+		for i := 0; i < 2; i++ {
+			response, err := c.Get(url)
+			assertResponse(t, response, err, http.StatusOK, wantBody)
+		}
+		if m.calls != 1 {
+			t.Errorf("calls got %d, expected %d", m.calls, 1)
+		}
+	})
+}
@@ -24,6 +24,9 @@ const (
 	CircuitBreakerStateOpen
 )
 
+// CircuitBreakerMiddlewareName is the name of CircuitBreaker middleware, see client.WithoutMiddleware
+const CircuitBreakerMiddlewareName = "circuit-breaker"
+
 const defaultInterval = time.Duration(0) * time.Second
 const defaultTimeout = time.Duration(60) * time.Second
 
@@ -108,7 +111,8 @@ func (c *CircuitBreakerCounts) clear() {
 // IsSuccessful is called with the error returned from the request, if not nil.
 // If IsSuccessful returns false, the error is considered a failure, and is counted towards tripping the circuit breaker.
 // If IsSuccessful returns true, the error will be returned to the caller without tripping the circuit breaker.
-// If IsSuccessful is nil, default IsSuccessful is used, which returns false for all non-nil errors.
+// If IsSuccessful is nil, default IsSuccessful is used, which returns false for all non-nil errors
+// and for responses classified as errors by client.StatusPolicy of the request.
 type CircuitBreakerSettings struct {
 	MaxRequests   uint32
 	Interval      time.Duration
@@ -176,6 +180,18 @@ func NewCircuitBreakerService(st CircuitBreakerSettings) *CircuitBreakerService
 	return cb
 }
 
+type isSuccessfulKey struct{}
+
+// WithCircuitBreakerIsSuccessful overrides CircuitBreakerSettings.IsSuccessful for a single request.
+func WithCircuitBreakerIsSuccessful(isSuccessful func(resp *http.Response, err error) bool) client.RequestOption {
+	return client.WithContextValue(isSuccessfulKey{}, isSuccessful)
+}
+
+// WithoutCircuitBreaker disables CircuitBreaker middleware for a single request.
+func WithoutCircuitBreaker() client.RequestOption {
+	return client.WithoutMiddleware(CircuitBreakerMiddlewareName)
+}
+
 // Execute process http.Client Do operation
 func (cb *CircuitBreakerService) Execute(_ *http.Client, next client.Responder) client.Responder {
 	return func(request *http.Request) (*http.Response, error) {
@@ -186,7 +202,13 @@ func (cb *CircuitBreakerService) Execute(_ *http.Client, next client.Responder)
 
 		result, err := next(request)
 
-		cb.afterRequest(generation, cb.isSuccessful(result, err))
+		isSuccessful := cb.isSuccessful
+		if request != nil {
+			if fn, ok := request.Context().Value(isSuccessfulKey{}).(func(*http.Response, error) bool); ok && fn != nil {
+				isSuccessful = fn
+			}
+		}
+		cb.afterRequest(generation, isSuccessful(result, err))
 		return result, err
 	}
 }
@@ -194,7 +216,7 @@ func (cb *CircuitBreakerService) Execute(_ *http.Client, next client.Responder)
 // CircuitBreaker adds Circuit Breaker middleware to requests
 func CircuitBreaker(c CircuitBreakerSettings) client.MiddlewareFunc {
 	cb := NewCircuitBreakerService(c)
-	return cb.Execute
+	return client.Named(CircuitBreakerMiddlewareName, cb.Execute)
 }
 
 func defaultReadyToTrip(counts CircuitBreakerCounts) bool {
@@ -202,8 +224,7 @@ func defaultReadyToTrip(counts CircuitBreakerCounts) bool {
 }
 
 func defaultIsSuccessful(resp *http.Response, err error) bool {
-	assertErr := client.AssertStatusCode(resp)
-	return err == nil && assertErr == nil
+	return err == nil && client.AssertStatusCode(resp) == nil
 }
 
 // State returns the current state of the CircuitBreakerService.
@@ -1,12 +1,14 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"runtime"
 	"testing"
 	"time"
 
+	"github.com/shuvava/go-enrichable-client/client"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -297,3 +299,43 @@ func newCustom() *CircuitBreakerService {
 
 	return NewCircuitBreakerService(customSt)
 }
+
+func TestDefaultIsSuccessfulStatusPolicy(t *testing.T) {
+	newResponse := func(ctx context.Context, code int) *http.Response {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://www.example.com", nil)
+		return &http.Response{StatusCode: code, Request: req}
+	}
+	ctx := context.Background()
+	assert.True(t, defaultIsSuccessful(newResponse(ctx, http.StatusOK), nil))
+	assert.False(t, defaultIsSuccessful(newResponse(ctx, http.StatusNotFound), nil))
+
+	ctx = client.WithStatusPolicy(ctx, client.LenientStatusPolicy)
+	assert.True(t, defaultIsSuccessful(newResponse(ctx, http.StatusNotFound), nil))
+	assert.False(t, defaultIsSuccessful(newResponse(ctx, http.StatusBadGateway), nil))
+}
+
+func TestCircuitBreakerRequestOptions(t *testing.T) {
+	cb := NewCircuitBreakerService(CircuitBreakerSettings{})
+	ctx := client.WithRequestOptions(context.Background(), WithCircuitBreakerIsSuccessful(func(*http.Response, error) bool {
+		return true
+	}))
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://www.example.com", nil)
+	fn := cb.Execute(http.DefaultClient, func(req *http.Request) (*http.Response, error) {
+		return nil, fmt.Errorf("fail")
+	})
+	for i := 0; i < 10; i++ {
+		_, _ = fn(req)
+	}
+	assert.Equal(t, CircuitBreakerStateClosed, cb.State())
+	assert.Equal(t, uint32(10), cb.Counts().TotalSuccesses)
+
+	ctx = client.WithRequestOptions(context.Background(), WithoutCircuitBreaker())
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, "https://www.example.com", nil)
+	fn = CircuitBreaker(CircuitBreakerSettings{})(http.DefaultClient, func(req *http.Request) (*http.Response, error) {
+		return nil, fmt.Errorf("fail")
+	})
+	for i := 0; i < 10; i++ {
+		_, err := fn(req)
+		assert.EqualError(t, err, "fail")
+	}
+}
@@ -0,0 +1,247 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/shuvava/go-enrichable-client/client"
+)
+
+/*
+Compression is a middleware that decodes compressed responses and compresses request bodies.
+*/
+
+// CompressionMiddlewareName is the name of Compression middleware, see client.WithoutMiddleware
+const CompressionMiddlewareName = "compression"
+
+// These constants are content codings supported by Compression middleware.
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+	EncodingBrotli  = "br"
+	EncodingZstd    = "zstd"
+)
+
+const defaultMinCompressSize = 1024
+
+// ErrUnsupportedEncoding is returned when the content coding is not supported.
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// CompressionConfig defines the config for Compression middleware.
+//
+// AcceptEncodings are content codings advertised in Accept-Encoding request header and decoded
+// from responses. If AcceptEncodings is nil, gzip, deflate, br and zstd are used.
+// Requests with Accept-Encoding header set by the caller are not modified and their responses are not decoded.
+//
+// RequestEncoding is the content coding of request bodies. If RequestEncoding is empty,
+// request bodies are not compressed. Compression panics if it is not one of the supported content codings.
+//
+// MinRequestSize is the minimum size of the request body to be compressed.
+// If MinRequestSize is 0, 1KB is used.
+type CompressionConfig struct {
+	AcceptEncodings []string
+	RequestEncoding string
+	MinRequestSize  int64
+}
+
+// decoders create readers decoding the content coding
+var decoders = map[string]func(io.Reader) (io.ReadCloser, error){
+	EncodingGzip: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	EncodingDeflate: func(r io.Reader) (io.ReadCloser, error) {
+		// "deflate" is zlib format (RFC 9110 section 8.4.1.2), but some servers send raw deflate
+		br := bufio.NewReader(r)
+		header, err := br.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	},
+	EncodingBrotli: func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(brotli.NewReader(r)), nil
+	},
+	EncodingZstd: func(r io.Reader) (io.ReadCloser, error) {
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	},
+}
+
+// zstdEncoder returns the encoder shared by requests, its EncodeAll is safe for concurrent use
+var zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+	return zstd.NewWriter(nil)
+})
+
+// encode compresses data with the content coding
+func encode(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case EncodingGzip:
+		w = gzip.NewWriter(&buf)
+	case EncodingDeflate:
+		w = zlib.NewWriter(&buf)
+	case EncodingBrotli:
+		w = brotli.NewWriter(&buf)
+	case EncodingZstd:
+		e, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return e.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedEncoding, encoding)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodingBody decodes the response body lazily on the first read
+type decodingBody struct {
+	body    io.ReadCloser
+	decoder func(io.Reader) (io.ReadCloser, error)
+	r       io.ReadCloser
+	err     error
+}
+
+func (b *decodingBody) Read(p []byte) (int, error) {
+	if b.r == nil && b.err == nil {
+		b.r, b.err = b.decoder(b.body)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.r.Read(p)
+}
+
+func (b *decodingBody) Close() error {
+	if b.r != nil {
+		_ = b.r.Close()
+	}
+	return b.body.Close()
+}
+
+// decodeResponse replaces the response body with the decoded body
+func decodeResponse(resp *http.Response) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	decoder, ok := decoders[encoding]
+	if !ok || resp.Body == nil || resp.Body == http.NoBody {
+		return
+	}
+	resp.Body = &decodingBody{body: resp.Body, decoder: decoder}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// compressRequest returns a copy of the request with the compressed body.
+// The body can be re-read with GetBody, e.g. by Retry middleware.
+func compressRequest(request *http.Request, encoding string, minSize int64) (*http.Request, error) {
+	if request.Body == nil || request.Body == http.NoBody || request.Header.Get("Content-Encoding") != "" ||
+		(request.ContentLength >= 0 && request.ContentLength < minSize) {
+		return request, nil
+	}
+	data, err := io.ReadAll(request.Body)
+	_ = request.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req := new(http.Request)
+	*req = *request
+	req.Header = request.Header.Clone()
+	if int64(len(data)) >= minSize {
+		if data, err = encode(encoding, data); err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	req.ContentLength = int64(len(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return req, nil
+}
+
+// WithoutCompression disables Compression middleware for a single request.
+func WithoutCompression() client.RequestOption {
+	return client.WithoutMiddleware(CompressionMiddlewareName)
+}
+
+// validate checks the request encoding is supported
+func (cfg CompressionConfig) validate() error {
+	if _, ok := decoders[cfg.RequestEncoding]; cfg.RequestEncoding != "" && !ok {
+		return fmt.Errorf("%w %q", ErrUnsupportedEncoding, cfg.RequestEncoding)
+	}
+	return nil
+}
+
+// Compression adds Compression middleware to requests.
+// It panics if RequestEncoding is not supported.
+func Compression(cfg CompressionConfig) client.MiddlewareFunc {
+	if err := cfg.validate(); err != nil {
+		panic(err)
+	}
+	encodings := cfg.AcceptEncodings
+	if encodings == nil {
+		encodings = []string{EncodingGzip, EncodingDeflate, EncodingBrotli, EncodingZstd}
+	}
+	accepted := map[string]bool{}
+	for _, e := range encodings {
+		accepted[strings.ToLower(e)] = true
+	}
+	acceptEncoding := strings.Join(encodings, ", ")
+	minSize := cfg.MinRequestSize
+	if minSize <= 0 {
+		minSize = defaultMinCompressSize
+	}
+	return client.Named(CompressionMiddlewareName, func(c *http.Client, next client.Responder) client.Responder {
+		return func(request *http.Request) (*http.Response, error) {
+			req := request
+			if cfg.RequestEncoding != "" {
+				var err error
+				if req, err = compressRequest(request, cfg.RequestEncoding, minSize); err != nil {
+					return nil, err
+				}
+			}
+			decode := req.Header.Get("Accept-Encoding") == "" && len(encodings) > 0
+			if decode {
+				if req == request {
+					req = new(http.Request)
+					*req = *request
+					req.Header = request.Header.Clone()
+				}
+				req.Header.Set("Accept-Encoding", acceptEncoding)
+			}
+			resp, err := next(req)
+			if err != nil || resp == nil || !decode {
+				return resp, err
+			}
+			if accepted[strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))] {
+				decodeResponse(resp)
+			}
+			return resp, nil
+		}
+	})
+}
@@ -0,0 +1,232 @@
+package middleware_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/shuvava/go-enrichable-client/client"
+	"github.com/shuvava/go-enrichable-client/middleware"
+)
+
+func compress(t *testing.T, encoding string, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case middleware.EncodingGzip:
+		w = gzip.NewWriter(&buf)
+	case middleware.EncodingDeflate:
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case middleware.EncodingBrotli:
+		w = brotli.NewWriter(&buf)
+	case middleware.EncodingZstd:
+		w, _ = zstd.NewWriter(&buf)
+	default:
+		return []byte(data)
+	}
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatalf("did not expect an error but got one %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("did not expect an error but got one %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decompress(t *testing.T, encoding string, data []byte) string {
+	t.Helper()
+	var r io.Reader
+	var err error
+	switch encoding {
+	case middleware.EncodingGzip:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case middleware.EncodingZstd:
+		r, err = zstd.NewReader(bytes.NewReader(data))
+	default:
+		r = bytes.NewReader(data)
+	}
+	if err != nil {
+		t.Fatalf("did not expect an error but got one %v", err)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("did not expect an error but got one %v", err)
+	}
+	return string(body)
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	t.Run("Should decode compressed responses", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com"
+			wantStatusCode = http.StatusOK
+			wantBody       = strings.Repeat(`{"id":1}`, 100)
+		)
+		for _, encoding := range []string{middleware.EncodingGzip, middleware.EncodingDeflate, "raw-deflate", middleware.EncodingBrotli, middleware.EncodingZstd} {
+			t.Run(encoding, func(t *testing.T) {
+				var acceptEncoding string
+				mock := client.NewMockTransport(true)
+				mock.RegisterResponder(http.MethodGet, url, func(request *http.Request) (*http.Response, error) {
+					acceptEncoding = request.Header.Get("Accept-Encoding")
+					header := make(http.Header)
+					header.Set("Content-Encoding", strings.TrimPrefix(encoding, "raw-"))
+					return &http.Response{
+						StatusCode: wantStatusCode,
+						Body:       io.NopCloser(bytes.NewReader(compress(t, encoding, wantBody))),
+						Header:     header,
+					}, nil
+				})
+				richClient := client.NewClient(mock)
+				richClient.Use(middleware.Compression(middleware.CompressionConfig{}))
+
+				response, err := richClient.Client.Get(url)
+				if err == nil && response.Header.Get("Content-Encoding") != "" {
+					t.Errorf("Content-Encoding should be removed, got %q", response.Header.Get("Content-Encoding"))
+				}
+				assertResponse(t, response, err, wantStatusCode, wantBody)
+				if acceptEncoding != "gzip, deflate, br, zstd" {
+					t.Errorf("Accept-Encoding got %q", acceptEncoding)
+				}
+			})
+		}
+	})
+	t.Run("Should not decode responses of caller Accept-Encoding", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com"
+			wantStatusCode = http.StatusOK
+			wantBody       = string(compress(t, middleware.EncodingGzip, "ok"))
+		)
+		mock := client.NewMockTransport(true)
+		mock.RegisterResponder(http.MethodGet, url, func(request *http.Request) (*http.Response, error) {
+			header := make(http.Header)
+			header.Set("Content-Encoding", middleware.EncodingGzip)
+			return &http.Response{
+				StatusCode: wantStatusCode,
+				Body:       io.NopCloser(bytes.NewBufferString(wantBody)),
+				Header:     header,
+			}, nil
+		})
+		richClient := client.NewClient(mock)
+		richClient.Use(middleware.Compression(middleware.CompressionConfig{}))
+
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Accept-Encoding", middleware.EncodingGzip)
+		response, err := richClient.Client.Do(req)
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+	})
+	t.Run("Should compress request bodies above threshold", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com"
+			wantStatusCode = http.StatusOK
+			wantBody       = "ok"
+			large          = strings.Repeat("a", 2048)
+		)
+		for _, encoding := range []string{middleware.EncodingGzip, middleware.EncodingZstd} {
+			t.Run(encoding, func(t *testing.T) {
+				var bodies, encodings []string
+				mock := client.NewMockTransport(true)
+				mock.RegisterResponder(http.MethodPost, url, func(request *http.Request) (*http.Response, error) {
+					data, _ := io.ReadAll(request.Body)
+					contentEncoding := request.Header.Get("Content-Encoding")
+					encodings = append(encodings, contentEncoding)
+					bodies = append(bodies, decompress(t, contentEncoding, data))
+					return &http.Response{
+						StatusCode: wantStatusCode,
+						Body:       io.NopCloser(bytes.NewBufferString(wantBody)),
+						Header:     make(http.Header),
+					}, nil
+				})
+				richClient := client.NewClient(mock)
+				richClient.Use(middleware.Compression(middleware.CompressionConfig{RequestEncoding: encoding}))
+
+				for _, body := range []string{large, "small"} {
+					response, err := richClient.Client.Post(url, client.ContentTypeText, strings.NewReader(body))
+					assertResponse(t, response, err, wantStatusCode, wantBody)
+				}
+				if len(bodies) != 2 || bodies[0] != large || bodies[1] != "small" {
+					t.Errorf("bodies got %v", bodies)
+				}
+				if encodings[0] != encoding || encodings[1] != "" {
+					t.Errorf("Content-Encoding got %v", encodings)
+				}
+			})
+		}
+	})
+	t.Run("Should keep compressed body rewindable for retries", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com"
+			wantStatusCode = http.StatusOK
+			wantBody       = "ok"
+			large          = strings.Repeat("a", 2048)
+		)
+		var bodies []string
+		mock := client.NewMockTransport(true)
+		mock.RegisterResponder(http.MethodPost, url, func(request *http.Request) (*http.Response, error) {
+			data, _ := io.ReadAll(request.Body)
+			bodies = append(bodies, decompress(t, request.Header.Get("Content-Encoding"), data))
+			code := wantStatusCode
+			if len(bodies) == 1 {
+				code = http.StatusServiceUnavailable
+			}
+			return &http.Response{
+				StatusCode: code,
+				Body:       io.NopCloser(bytes.NewBufferString(wantBody)),
+				Header:     make(http.Header),
+			}, nil
+		})
+		richClient := client.NewClient(mock)
+		richClient.Use(
+			middleware.Compression(middleware.CompressionConfig{RequestEncoding: middleware.EncodingGzip}),
+			middleware.RetryWithConfig(newRetryConfig()),
+		)
+
+		response, err := richClient.Client.Post(url, client.ContentTypeText, strings.NewReader(large))
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+		if len(bodies) != 2 || bodies[0] != large || bodies[1] != large {
+			t.Errorf("bodies got %d, want both equal to the request body", len(bodies))
+		}
+	})
+	t.Run("Should fail on unsupported request encoding", func(t *testing.T) {
+		defer func() {
+			err, _ := recover().(error)
+			if !errors.Is(err, middleware.ErrUnsupportedEncoding) {
+				t.Errorf("expected ErrUnsupportedEncoding but got %v", err)
+			}
+		}()
+		middleware.Compression(middleware.CompressionConfig{RequestEncoding: "lzma"})
+	})
+	t.Run("Should be disabled by request option", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com"
+			wantStatusCode = http.StatusOK
+		)
+		var acceptEncoding string
+		mock := client.NewMockTransport(true)
+		mock.RegisterResponder(http.MethodGet, url, func(request *http.Request) (*http.Response, error) {
+			acceptEncoding = request.Header.Get("Accept-Encoding")
+			return &http.Response{StatusCode: wantStatusCode, Body: http.NoBody, Header: make(http.Header)}, nil
+		})
+		richClient := client.NewClient(mock)
+		richClient.Use(middleware.Compression(middleware.CompressionConfig{}))
+
+		req, _ := client.NewRequest(context.Background(), http.MethodGet, url, nil, middleware.WithoutCompression())
+		response, err := richClient.Client.Do(req.Request)
+		assertResponse(t, response, err, wantStatusCode, "")
+		if acceptEncoding != "" {
+			t.Errorf("Accept-Encoding got %q, expected none", acceptEncoding)
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// jwtAlgorithm returns JWS algorithm (RFC 7518) and hash of the key
+func jwtAlgorithm(key crypto.Signer) (string, crypto.Hash, error) {
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		return "RS256", crypto.SHA256, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve.Params().BitSize {
+		case 256:
+			return "ES256", crypto.SHA256, nil
+		case 384:
+			return "ES384", crypto.SHA384, nil
+		case 521:
+			return "ES512", crypto.SHA512, nil
+		}
+		return "", 0, fmt.Errorf("unsupported ecdsa curve %s", pub.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "EdDSA", 0, nil
+	default:
+		return "", 0, fmt.Errorf("unsupported jwt signing key %T", pub)
+	}
+}
+
+// signJWT returns compact JWS of the claims signed by the key.
+// The alg header is set according to the key.
+func signJWT(key crypto.Signer, header map[string]interface{}, claims interface{}) (string, error) {
+	alg, hash, err := jwtAlgorithm(key)
+	if err != nil {
+		return "", err
+	}
+	h := map[string]interface{}{"alg": alg}
+	for k, v := range header {
+		h[k] = v
+	}
+	headerJSON, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	digest := []byte(signingInput)
+	if hash != 0 {
+		hf := hash.New()
+		hf.Write(digest)
+		digest = hf.Sum(nil)
+	}
+	sig, err := key.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		return "", err
+	}
+	if pub, ok := key.Public().(*ecdsa.PublicKey); ok {
+		// JWS uses fixed size R || S instead of ASN.1 signature (RFC 7518 section 3.4)
+		var rs struct{ R, S *big.Int }
+		if _, err = asn1.Unmarshal(sig, &rs); err != nil {
+			return "", err
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		rs.R.FillBytes(sig[:size])
+		rs.S.FillBytes(sig[size:])
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// newJTI returns random JWT ID
+func newJTI() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
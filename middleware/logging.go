@@ -0,0 +1,257 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/shuvava/go-enrichable-client/client"
+)
+
+/*
+Logging is a middleware that emits request and response events via log/slog.
+*/
+
+// LoggingMiddlewareName is the name of Logging middleware, see client.WithoutMiddleware
+const LoggingMiddlewareName = "logging"
+
+const (
+	redacted                = "[REDACTED]"
+	defaultLoggingBodyLimit = 4 * 1024
+)
+
+var (
+	// DefaultRedactHeaders are headers redacted by Logging middleware when LoggingConfig.RedactHeaders is nil
+	DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "DPoP"}
+	// DefaultRedactParams are query parameters and JSON fields redacted by Logging middleware
+	// when LoggingConfig.RedactQueryParams or LoggingConfig.RedactJSONFields is nil
+	DefaultRedactParams = []string{"access_token", "refresh_token", "id_token", "client_secret", "password"}
+)
+
+// LoggingConfig defines the config for Logging middleware.
+type LoggingConfig struct {
+	// Logger is the logger events are written to. If Logger is nil, slog.Default() is used.
+	Logger *slog.Logger
+	// Level is the level of request and response events.
+	// Responses classified as errors by client.StatusPolicy are logged with slog.LevelWarn,
+	// transport errors with slog.LevelError.
+	Level slog.Level
+	// LogBodies enables logging of request and response bodies.
+	LogBodies bool
+	// MaxBodySize limits the size of logged bodies. If MaxBodySize is 0, 4KB is used.
+	MaxBodySize int
+	// RedactHeaders is a list of headers whose values are replaced with "[REDACTED]".
+	// If RedactHeaders is nil, DefaultRedactHeaders is used.
+	RedactHeaders []string
+	// RedactQueryParams is a list of query (and form body) parameters whose values are redacted.
+	// If RedactQueryParams is nil, DefaultRedactParams is used.
+	RedactQueryParams []string
+	// RedactJSONFields is a list of JSON body fields whose values are redacted.
+	// If RedactJSONFields is nil, DefaultRedactParams is used.
+	RedactJSONFields []string
+}
+
+// redactor hides sensitive data in logged values
+type redactor struct {
+	headers map[string]bool
+	params  map[string]bool
+	json    *regexp.Regexp
+	query   *regexp.Regexp // redacted parameters of URLs in free text
+}
+
+func newRedactor(cfg LoggingConfig) *redactor {
+	r := &redactor{headers: map[string]bool{}, params: map[string]bool{}}
+	headers := cfg.RedactHeaders
+	if headers == nil {
+		headers = DefaultRedactHeaders
+	}
+	for _, h := range headers {
+		r.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	params := cfg.RedactQueryParams
+	if params == nil {
+		params = DefaultRedactParams
+	}
+	for _, p := range params {
+		r.params[strings.ToLower(p)] = true
+	}
+	if len(params) > 0 {
+		quoted := make([]string, len(params))
+		for i, p := range params {
+			quoted[i] = regexp.QuoteMeta(url.QueryEscape(p))
+		}
+		r.query = regexp.MustCompile(`(?i)([?&](?:` + strings.Join(quoted, "|") + `)=)[^&#\s"]*`)
+	}
+	fields := cfg.RedactJSONFields
+	if fields == nil {
+		fields = DefaultRedactParams
+	}
+	if len(fields) > 0 {
+		quoted := make([]string, len(fields))
+		for i, f := range fields {
+			quoted[i] = regexp.QuoteMeta(f)
+		}
+		// matches "field": "string value" or "field": literal, works on truncated bodies
+		r.json = regexp.MustCompile(`(?i)("(?:` + strings.Join(quoted, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+	}
+	return r
+}
+
+func (r *redactor) header(h http.Header) slog.Value {
+	attrs := make([]slog.Attr, 0, len(h))
+	for k, v := range h {
+		value := strings.Join(v, ", ")
+		if r.headers[http.CanonicalHeaderKey(k)] {
+			value = redacted
+		}
+		attrs = append(attrs, slog.String(k, value))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// values encodes values with redacted parameters
+func (r *redactor) values(values url.Values) string {
+	for k := range values {
+		if r.params[strings.ToLower(k)] {
+			values[k] = []string{redacted}
+		}
+	}
+	return strings.ReplaceAll(values.Encode(), url.QueryEscape(redacted), redacted)
+}
+
+func (r *redactor) url(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	if u.RawQuery == "" && u.User == nil {
+		return u.String()
+	}
+	c := *u
+	c.User = nil
+	c.RawQuery = r.values(u.Query())
+	return c.String()
+}
+
+// error returns the error text with redacted query parameters of URLs,
+// e.g. of *url.Error or errors of Retry middleware
+func (r *redactor) error(err error) string {
+	if r.query == nil {
+		return err.Error()
+	}
+	return r.query.ReplaceAllString(err.Error(), "${1}"+redacted)
+}
+
+func (r *redactor) body(contentType string, body []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == client.ContentTypeForm:
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			return r.values(values)
+		}
+		return redacted
+	case r.json != nil:
+		return r.json.ReplaceAllString(string(body), `${1}"`+redacted+`"`)
+	default:
+		return string(body)
+	}
+}
+
+// peekBody reads up to limit bytes of the body and returns the body restored for further reading
+func peekBody(body io.ReadCloser, limit int) ([]byte, io.ReadCloser) {
+	if body == nil || body == http.NoBody {
+		return nil, body
+	}
+	buf, err := io.ReadAll(io.LimitReader(body, int64(limit)))
+	restored := struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), body), body}
+	if err != nil {
+		return nil, restored
+	}
+	return buf, restored
+}
+
+// Logging is a middleware that logs requests and responses with log/slog.
+// Requests are logged at LoggingConfig.Level, responses classified as errors by
+// client.StatusPolicy at slog.LevelWarn or above and transport errors at slog.LevelError.
+// Place it after Retry middleware to log every attempt.
+func Logging(cfg LoggingConfig) client.MiddlewareFunc {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	limit := cfg.MaxBodySize
+	if limit <= 0 {
+		limit = defaultLoggingBodyLimit
+	}
+	r := newRedactor(cfg)
+	return client.Named(LoggingMiddlewareName, func(c *http.Client, next client.Responder) client.Responder {
+		return func(request *http.Request) (*http.Response, error) {
+			ctx := request.Context()
+			enabled := logger.Enabled(ctx, cfg.Level)
+			if !enabled && !logger.Enabled(ctx, slog.LevelError) {
+				return next(request)
+			}
+			common := []slog.Attr{
+				slog.String("method", request.Method),
+				slog.String("url", r.url(request.URL)),
+				slog.Int("attempt", AttemptFromContext(ctx)),
+			}
+			if enabled {
+				attrs := append(common[:len(common):len(common)],
+					slog.Int64("bytes", request.ContentLength),
+					slog.Any("headers", r.header(request.Header)),
+				)
+				if cfg.LogBodies && request.Body != nil && request.Body != http.NoBody {
+					// do not modify the caller's request
+					req := new(http.Request)
+					*req = *request
+					var body []byte
+					body, req.Body = peekBody(req.Body, limit)
+					request = req
+					if len(body) > 0 {
+						attrs = append(attrs, slog.String("body", r.body(request.Header.Get("Content-Type"), body)))
+					}
+				}
+				logger.LogAttrs(ctx, cfg.Level, "http request", attrs...)
+			}
+
+			start := time.Now()
+			resp, err := next(request)
+			attrs := append(common, slog.Duration("duration", time.Since(start)))
+			if err != nil {
+				attrs = append(attrs, slog.String("error", r.error(err)))
+				logger.LogAttrs(ctx, slog.LevelError, "http request failed", attrs...)
+				return resp, err
+			}
+			level := cfg.Level
+			if client.ClassifyResponse(resp) == client.StatusClassError && level < slog.LevelWarn {
+				level = slog.LevelWarn
+			}
+			if !logger.Enabled(ctx, level) {
+				return resp, nil
+			}
+			attrs = append(attrs,
+				slog.Int("status", resp.StatusCode),
+				slog.Int64("bytes", resp.ContentLength),
+				slog.Any("headers", r.header(resp.Header)),
+			)
+			if cfg.LogBodies {
+				var body []byte
+				body, resp.Body = peekBody(resp.Body, limit)
+				if len(body) > 0 {
+					attrs = append(attrs, slog.String("body", r.body(resp.Header.Get("Content-Type"), body)))
+				}
+			}
+			logger.LogAttrs(ctx, level, "http response", attrs...)
+			return resp, nil
+		}
+	})
+}
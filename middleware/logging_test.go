@@ -0,0 +1,192 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/shuvava/go-enrichable-client/client"
+	"github.com/shuvava/go-enrichable-client/middleware"
+)
+
+func parseLogEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	t.Run("Should log request and response", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com/users?page=1&access_token=secret"
+			wantStatusCode = http.StatusOK
+			wantBody       = "ok"
+		)
+		var buf bytes.Buffer
+		m := createGetMock(url, wantStatusCode, wantBody, -1, 0)
+		richClient := client.NewClient(m.mock)
+		richClient.Use(middleware.Logging(middleware.LoggingConfig{
+			Logger: slog.New(slog.NewJSONHandler(&buf, nil)),
+		}))
+
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Authorization", "Bearer secret-token")
+		response, err := richClient.Client.Do(req)
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+
+		entries := parseLogEntries(t, &buf)
+		if len(entries) != 2 {
+			t.Fatalf("log entries got %d, want %d", len(entries), 2)
+		}
+		if entries[0]["msg"] != "http request" || entries[1]["msg"] != "http response" {
+			t.Errorf("log messages got %q, %q", entries[0]["msg"], entries[1]["msg"])
+		}
+		if entries[0]["method"] != http.MethodGet {
+			t.Errorf("method got %v, want %s", entries[0]["method"], http.MethodGet)
+		}
+		if entries[0]["attempt"] != float64(1) {
+			t.Errorf("attempt got %v, want %d", entries[0]["attempt"], 1)
+		}
+		if entries[1]["status"] != float64(wantStatusCode) {
+			t.Errorf("status got %v, want %d", entries[1]["status"], wantStatusCode)
+		}
+		if _, ok := entries[1]["duration"]; !ok {
+			t.Error("expected duration in response entry")
+		}
+		headers, _ := entries[0]["headers"].(map[string]interface{})
+		if headers["Authorization"] != "[REDACTED]" {
+			t.Errorf("Authorization got %v, want [REDACTED]", headers["Authorization"])
+		}
+		if strings.Contains(buf.String(), "secret") {
+			t.Errorf("log contains secret: %s", buf.String())
+		}
+		if !strings.Contains(buf.String(), "access_token=[REDACTED]") {
+			t.Errorf("url got %v", entries[0]["url"])
+		}
+		if req.Header.Get("Authorization") != "Bearer secret-token" {
+			t.Error("request header should not be modified")
+		}
+	})
+	t.Run("Should redact and limit bodies", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com/oauth/token"
+			wantStatusCode = http.StatusOK
+			wantBody       = `{"token_type":"Bearer","expires_in":3599,"access_token": "secret-token"}`
+		)
+		var buf bytes.Buffer
+		m := createPostMock(url, wantStatusCode, wantBody, -1, 0)
+		richClient := client.NewClient(m.mock)
+		richClient.Use(middleware.Logging(middleware.LoggingConfig{
+			Logger:      slog.New(slog.NewJSONHandler(&buf, nil)),
+			LogBodies:   true,
+			MaxBodySize: 60,
+		}))
+
+		form := "grant_type=client_credentials&client_id=1&client_secret=secret-value"
+		response, err := richClient.Client.Post(url, client.ContentTypeForm, strings.NewReader(form))
+		// body read by the middleware must be available to the caller
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+
+		entries := parseLogEntries(t, &buf)
+		if len(entries) != 2 {
+			t.Fatalf("log entries got %d, want %d", len(entries), 2)
+		}
+		if body, _ := entries[0]["body"].(string); !strings.Contains(body, "client_id=1") {
+			t.Errorf("request body got %q", body)
+		}
+		if body, _ := entries[1]["body"].(string); len(body) == 0 || !strings.Contains(body, "[REDACTED]") {
+			t.Errorf("response body got %q", body)
+		}
+		if strings.Contains(buf.String(), "secret-") {
+			t.Errorf("log contains secret: %s", buf.String())
+		}
+	})
+	t.Run("Should log every retry attempt", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com"
+			wantStatusCode = http.StatusOK
+			wantBody       = "ok"
+		)
+		var buf bytes.Buffer
+		m := createGetMock(url, wantStatusCode, wantBody, 1, http.StatusServiceUnavailable)
+		richClient := client.NewClient(m.mock)
+		richClient.Use(
+			middleware.RetryWithConfig(newRetryConfig()),
+			middleware.Logging(middleware.LoggingConfig{Logger: slog.New(slog.NewJSONHandler(&buf, nil))}),
+		)
+
+		response, err := richClient.Client.Get(url)
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+
+		entries := parseLogEntries(t, &buf)
+		if len(entries) != 4 {
+			t.Fatalf("log entries got %d, want %d", len(entries), 4)
+		}
+		if entries[1]["level"] != slog.LevelWarn.String() {
+			t.Errorf("level got %v, want %s", entries[1]["level"], slog.LevelWarn)
+		}
+		if entries[3]["attempt"] != float64(2) {
+			t.Errorf("attempt got %v, want %d", entries[3]["attempt"], 2)
+		}
+	})
+	t.Run("Should redact query parameters in transport errors", func(t *testing.T) {
+		rawURL := "https://www.example.com/items?access_token=secret&id=1"
+		var buf bytes.Buffer
+		mock := client.NewMockTransport(true)
+		mock.RegisterResponder(http.MethodGet, rawURL, func(request *http.Request) (*http.Response, error) {
+			return nil, &url.Error{Op: "Get", URL: request.URL.String(), Err: errors.New("connection refused")}
+		})
+		richClient := client.NewClient(mock)
+		richClient.Use(
+			middleware.Logging(middleware.LoggingConfig{Logger: slog.New(slog.NewJSONHandler(&buf, nil))}),
+			middleware.RetryWithConfig(newRetryConfig()),
+		)
+
+		if _, err := richClient.Client.Get(rawURL); err == nil {
+			t.Fatalf("error should be returned")
+		}
+		entries := parseLogEntries(t, &buf)
+		if len(entries) != 2 {
+			t.Fatalf("log entries got %d, want %d", len(entries), 2)
+		}
+		logged, _ := entries[1]["error"].(string)
+		if strings.Contains(logged, "secret") || !strings.Contains(logged, "access_token=[REDACTED]&id=1") {
+			t.Errorf("error got %q", logged)
+		}
+	})
+	t.Run("Should skip disabled levels", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com"
+			wantStatusCode = http.StatusOK
+			wantBody       = "ok"
+		)
+		var buf bytes.Buffer
+		m := createGetMock(url, wantStatusCode, wantBody, -1, 0)
+		richClient := client.NewClient(m.mock)
+		richClient.Use(middleware.Logging(middleware.LoggingConfig{
+			Logger: slog.New(slog.NewJSONHandler(&buf, nil)),
+			Level:  slog.LevelDebug,
+		}))
+
+		response, err := richClient.Client.Get(url)
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+		if buf.Len() != 0 {
+			t.Errorf("expected no log entries, got %s", buf.String())
+		}
+	})
+}
@@ -0,0 +1,300 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/shuvava/go-enrichable-client/client"
+)
+
+/*
+Metrics is a middleware that exposes RED (rate, errors, duration) metrics of outgoing requests
+in Prometheus format.
+*/
+
+const (
+	// MetricsMiddlewareName is the name of Metrics middleware, see client.WithoutMiddleware
+	MetricsMiddlewareName = "metrics"
+
+	// otherLabel is the label value of hosts and routes exceeding cardinality limits
+	otherLabel       = "other"
+	defaultMaxHosts  = 100
+	defaultNamespace = "http_client"
+	transportError   = "error"
+)
+
+// MetricsConfig defines the config for Metrics middleware.
+//
+// Requests are labelled by host, method, route template (see client.WithRoute) and status class
+// ("2xx", "4xx", "5xx", ... or "error" for transport errors). Raw URLs never become labels:
+// requests without route template are labelled with route "other".
+type MetricsConfig struct {
+	// Registerer registers the collectors. If Registerer is nil, prometheus.DefaultRegisterer is used.
+	Registerer prometheus.Registerer
+	// Namespace is the prefix of metric names. If Namespace is empty, "http_client" is used.
+	Namespace string
+	// Subsystem is the optional part of metric names after Namespace.
+	Subsystem string
+	// ConstLabels are labels added to all metrics, e.g. the name of the client.
+	ConstLabels prometheus.Labels
+	// Buckets of request duration histogram. If Buckets is nil, prometheus.DefBuckets is used.
+	Buckets []float64
+	// MaxHosts limits the number of distinct host labels. Requests to other hosts are labelled
+	// with host "other". If MaxHosts is 0, 100 is used.
+	MaxHosts int
+	// HostLabel returns the host label of the request. If HostLabel is nil, URL hostname is used.
+	HostLabel func(*http.Request) string
+	// RouteLabel returns the route label of the request. If RouteLabel is nil,
+	// client.RouteFromContext is used.
+	RouteLabel func(*http.Request) string
+}
+
+// MetricsService collects metrics of requests, retries and circuit breakers.
+type MetricsService struct {
+	hostLabel  func(*http.Request) string
+	routeLabel func(*http.Request) string
+	maxHosts   int
+
+	requests    *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	inFlight    *prometheus.GaugeVec
+	retries     *prometheus.CounterVec
+	cbState     *prometheus.GaugeVec
+	transitions *prometheus.CounterVec
+
+	mu    sync.RWMutex
+	hosts map[string]bool
+}
+
+// NewMetricsService creates MetricsService and registers its collectors.
+func NewMetricsService(cfg MetricsConfig) (*MetricsService, error) {
+	reg := cfg.Registerer
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	namespace := cfg.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	buckets := cfg.Buckets
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+	m := &MetricsService{
+		hostLabel:  cfg.HostLabel,
+		routeLabel: cfg.RouteLabel,
+		maxHosts:   cfg.MaxHosts,
+		hosts:      map[string]bool{},
+	}
+	if m.hostLabel == nil {
+		m.hostLabel = func(r *http.Request) string { return r.URL.Hostname() }
+	}
+	if m.routeLabel == nil {
+		m.routeLabel = func(r *http.Request) string { return client.RouteFromContext(r.Context()) }
+	}
+	if m.maxHosts <= 0 {
+		m.maxHosts = defaultMaxHosts
+	}
+	opts := func(name, help string) prometheus.Opts {
+		return prometheus.Opts{
+			Namespace:   namespace,
+			Subsystem:   cfg.Subsystem,
+			Name:        name,
+			Help:        help,
+			ConstLabels: cfg.ConstLabels,
+		}
+	}
+	m.requests = prometheus.NewCounterVec(prometheus.CounterOpts(opts("requests_total",
+		"Total number of requests by host, method, route and status class.")),
+		[]string{"host", "method", "route", "status_class"})
+	m.duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   namespace,
+		Subsystem:   cfg.Subsystem,
+		Name:        "request_duration_seconds",
+		Help:        "Duration of requests including retries by host, method, route and status class.",
+		ConstLabels: cfg.ConstLabels,
+		Buckets:     buckets,
+	}, []string{"host", "method", "route", "status_class"})
+	m.inFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("requests_in_flight",
+		"Number of requests in flight by host.")),
+		[]string{"host"})
+	m.retries = prometheus.NewCounterVec(prometheus.CounterOpts(opts("retries_total",
+		"Total number of retry attempts made by Retry middleware by host, method and route.")),
+		[]string{"host", "method", "route"})
+	m.cbState = prometheus.NewGaugeVec(prometheus.GaugeOpts(opts("circuit_breaker_state",
+		"Current state of the circuit breaker, 1 for the current state and 0 for others.")),
+		[]string{"name", "state"})
+	m.transitions = prometheus.NewCounterVec(prometheus.CounterOpts(opts("circuit_breaker_transitions_total",
+		"Total number of circuit breaker state transitions.")),
+		[]string{"name", "from", "to"})
+	// collectors registered by another service with the same config are reused
+	var err error
+	if m.requests, err = register(reg, m.requests); err != nil {
+		return nil, err
+	}
+	if m.duration, err = register(reg, m.duration); err != nil {
+		return nil, err
+	}
+	if m.inFlight, err = register(reg, m.inFlight); err != nil {
+		return nil, err
+	}
+	if m.retries, err = register(reg, m.retries); err != nil {
+		return nil, err
+	}
+	if m.cbState, err = register(reg, m.cbState); err != nil {
+		return nil, err
+	}
+	if m.transitions, err = register(reg, m.transitions); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// register registers the collector returning the already registered equal collector if any
+func register[T prometheus.Collector](reg prometheus.Registerer, c T) (T, error) {
+	err := reg.Register(c)
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(T); ok {
+			return existing, nil
+		}
+	}
+	return c, err
+}
+
+// host returns the host label of the request limited by MaxHosts
+func (m *MetricsService) host(r *http.Request) string {
+	host := m.hostLabel(r)
+	m.mu.RLock()
+	known := m.hosts[host]
+	m.mu.RUnlock()
+	if known {
+		return host
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hosts[host] {
+		return host
+	}
+	if len(m.hosts) >= m.maxHosts {
+		return otherLabel
+	}
+	m.hosts[host] = true
+	return host
+}
+
+func (m *MetricsService) route(r *http.Request) string {
+	if route := m.routeLabel(r); route != "" {
+		return route
+	}
+	return otherLabel
+}
+
+// methodLabel returns the method label limited to standard methods
+func methodLabel(method string) string {
+	switch method {
+	case "", http.MethodGet:
+		return http.MethodGet
+	case http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return otherLabel
+	}
+}
+
+// statusClass returns the status class label of the response
+func statusClass(resp *http.Response, err error) string {
+	if err != nil || resp == nil {
+		return transportError
+	}
+	if resp.StatusCode < 100 || resp.StatusCode > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(resp.StatusCode/100) + "xx"
+}
+
+// requestLabels are labels of the request
+type requestLabels struct {
+	host, method, route string
+}
+
+// observeRetry is the attempt hook of Retry middleware counting retry attempts of the request
+func (m *MetricsService) observeRetry(l *requestLabels) attemptHook {
+	return func(request *http.Request) (*http.Request, func(*http.Response, error), error) {
+		if AttemptFromContext(request.Context()) > 1 {
+			m.retries.WithLabelValues(l.host, l.method, l.route).Inc()
+		}
+		return request, nil, nil
+	}
+}
+
+// Execute measures http.Client Do operation
+func (m *MetricsService) Execute(_ *http.Client, next client.Responder) client.Responder {
+	return func(request *http.Request) (*http.Response, error) {
+		l := &requestLabels{
+			host:   m.host(request),
+			method: methodLabel(request.Method),
+			route:  m.route(request),
+		}
+		inFlight := m.inFlight.WithLabelValues(l.host)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		resp, err := next(request.WithContext(withAttemptHook(request.Context(), m.observeRetry(l))))
+		class := statusClass(resp, err)
+		m.requests.WithLabelValues(l.host, l.method, l.route, class).Inc()
+		m.duration.WithLabelValues(l.host, l.method, l.route, class).Observe(time.Since(start).Seconds())
+		return resp, err
+	}
+}
+
+// InstrumentCircuitBreaker returns a copy of settings reporting state and transitions of
+// the circuit breaker with the name. The OnStateChange of settings is still called.
+//
+//	cb := middleware.CircuitBreaker(metrics.InstrumentCircuitBreaker("payments", settings))
+func (m *MetricsService) InstrumentCircuitBreaker(name string, settings CircuitBreakerSettings) CircuitBreakerSettings {
+	m.setCircuitBreakerState(name, CircuitBreakerStateClosed)
+	onStateChange := settings.OnStateChange
+	settings.OnStateChange = func(from CircuitBreakerState, to CircuitBreakerState) {
+		m.transitions.WithLabelValues(name, from.String(), to.String()).Inc()
+		m.setCircuitBreakerState(name, to)
+		if onStateChange != nil {
+			onStateChange(from, to)
+		}
+	}
+	return settings
+}
+
+func (m *MetricsService) setCircuitBreakerState(name string, current CircuitBreakerState) {
+	for _, state := range []CircuitBreakerState{CircuitBreakerStateClosed, CircuitBreakerStateHalfOpen, CircuitBreakerStateOpen} {
+		value := 0.0
+		if state == current {
+			value = 1
+		}
+		m.cbState.WithLabelValues(name, state.String()).Set(value)
+	}
+}
+
+// Middleware returns Metrics middleware collecting metrics into the service.
+// Place it before Retry middleware to measure logical requests and count retries.
+func (m *MetricsService) Middleware() client.MiddlewareFunc {
+	return client.Named(MetricsMiddlewareName, m.Execute)
+}
+
+// Metrics creates Metrics middleware. Clients created with the same config share the collectors.
+// It panics if the collectors cannot be registered, e.g. other collectors with the same names
+// but different labels are registered, use NewMetricsService to handle the error.
+func Metrics(cfg MetricsConfig) client.MiddlewareFunc {
+	m, err := NewMetricsService(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return m.Middleware()
+}
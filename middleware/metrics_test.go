@@ -0,0 +1,145 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/shuvava/go-enrichable-client/client"
+	"github.com/shuvava/go-enrichable-client/middleware"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	t.Run("Should count requests and retries by route template", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com/users/42"
+			wantStatusCode = http.StatusOK
+			wantBody       = `{"id":42}`
+		)
+		reg := prometheus.NewRegistry()
+		metrics, err := middleware.NewMetricsService(middleware.MetricsConfig{Registerer: reg})
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		m := createGetMock(url, wantStatusCode, wantBody, 1, http.StatusServiceUnavailable)
+		richClient := client.NewClient(m.mock)
+		richClient.Use(metrics.Middleware(), middleware.RetryWithConfig(newRetryConfig()))
+
+		req, err := client.NewRequest(context.Background(), http.MethodGet, "https://www.example.com/users/{id}", nil,
+			client.WithPathParam("id", 42))
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		response, err := richClient.Client.Do(req.Request)
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+
+		want := `
+# HELP http_client_requests_total Total number of requests by host, method, route and status class.
+# TYPE http_client_requests_total counter
+http_client_requests_total{host="www.example.com",method="GET",route="/users/{id}",status_class="2xx"} 1
+# HELP http_client_retries_total Total number of retry attempts made by Retry middleware by host, method and route.
+# TYPE http_client_retries_total counter
+http_client_retries_total{host="www.example.com",method="GET",route="/users/{id}"} 1
+`
+		if err = testutil.GatherAndCompare(reg, strings.NewReader(want),
+			"http_client_requests_total", "http_client_retries_total"); err != nil {
+			t.Error(err)
+		}
+		if got := testutil.CollectAndCount(reg, "http_client_request_duration_seconds"); got != 1 {
+			t.Errorf("duration series got %d, want %d", got, 1)
+		}
+	})
+	t.Run("Should not use raw URLs and unknown hosts as labels", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		metrics, _ := middleware.NewMetricsService(middleware.MetricsConfig{Registerer: reg, MaxHosts: 1})
+		mock := client.NewMockTransport(true)
+		richClient := client.NewClient(mock)
+		richClient.Use(metrics.Middleware())
+
+		_, _ = richClient.Client.Get("https://a.example.com/users/1")
+		_, _ = richClient.Client.Get("https://b.example.com/users/2")
+
+		want := `
+# HELP http_client_requests_total Total number of requests by host, method, route and status class.
+# TYPE http_client_requests_total counter
+http_client_requests_total{host="a.example.com",method="GET",route="other",status_class="error"} 1
+http_client_requests_total{host="other",method="GET",route="other",status_class="error"} 1
+`
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "http_client_requests_total"); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Should report circuit breaker transitions", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com"
+			wantStatusCode = http.StatusInternalServerError
+		)
+		reg := prometheus.NewRegistry()
+		metrics, _ := middleware.NewMetricsService(middleware.MetricsConfig{Registerer: reg})
+		m := createGetMock(url, wantStatusCode, "error", -1, 0)
+		richClient := client.NewClient(m.mock)
+		richClient.Use(middleware.CircuitBreaker(metrics.InstrumentCircuitBreaker("example", middleware.CircuitBreakerSettings{
+			ReadyToTrip: func(counts middleware.CircuitBreakerCounts) bool {
+				return counts.ConsecutiveFailures >= 1
+			},
+		})))
+
+		response, err := richClient.Client.Get(url)
+		assertResponse(t, response, err, wantStatusCode, "error")
+
+		want := `
+# HELP http_client_circuit_breaker_state Current state of the circuit breaker, 1 for the current state and 0 for others.
+# TYPE http_client_circuit_breaker_state gauge
+http_client_circuit_breaker_state{name="example",state="closed"} 0
+http_client_circuit_breaker_state{name="example",state="half-open"} 0
+http_client_circuit_breaker_state{name="example",state="open"} 1
+# HELP http_client_circuit_breaker_transitions_total Total number of circuit breaker state transitions.
+# TYPE http_client_circuit_breaker_transitions_total counter
+http_client_circuit_breaker_transitions_total{from="closed",name="example",to="open"} 1
+`
+		if err = testutil.GatherAndCompare(reg, strings.NewReader(want),
+			"http_client_circuit_breaker_state", "http_client_circuit_breaker_transitions_total"); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Should share collectors of clients with the same registerer", func(t *testing.T) {
+		url := "https://www.example.com"
+		reg := prometheus.NewRegistry()
+		for i := 0; i < 2; i++ {
+			m := createGetMock(url, http.StatusOK, "ok", -1, 0)
+			richClient := client.NewClient(m.mock)
+			richClient.Use(middleware.Metrics(middleware.MetricsConfig{Registerer: reg}))
+			response, err := richClient.Client.Get(url)
+			assertResponse(t, response, err, http.StatusOK, "ok")
+		}
+
+		want := `
+# HELP http_client_requests_total Total number of requests by host, method, route and status class.
+# TYPE http_client_requests_total counter
+http_client_requests_total{host="www.example.com",method="GET",route="other",status_class="2xx"} 2
+`
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "http_client_requests_total"); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Should fail on conflicting collectors", func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_requests_total",
+			Help: "Total number of requests by host, method, route and status class.",
+		}, []string{"host"}))
+		if _, err := middleware.NewMetricsService(middleware.MetricsConfig{Registerer: reg}); err == nil {
+			t.Error("error should be returned")
+		}
+		defer func() {
+			if recover() == nil {
+				t.Error("Metrics should panic")
+			}
+		}()
+		middleware.Metrics(middleware.MetricsConfig{Registerer: reg})
+	})
+}
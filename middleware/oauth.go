@@ -1,9 +1,11 @@
 package middleware
 
 import (
-	"fmt"
+	"context"
+	"crypto"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -11,13 +13,92 @@ import (
 	"github.com/shuvava/go-enrichable-client/client"
 )
 
+// OAuthMiddlewareName is the name of OAuth middleware, see client.WithoutMiddleware
+const OAuthMiddlewareName = "oauth"
+
+const (
+	defaultOAuthRefreshBefore = 30 * time.Second
+	defaultOAuthErrorBackoff  = time.Second
+	maxOAuthErrorBackoff      = time.Minute
+)
+
+// invalidTokenChallenge matches WWW-Authenticate challenge of rejected bearer token (RFC 6750 section 3.1)
+var invalidTokenChallenge = regexp.MustCompile(`(?i)\bbearer\b.*\berror\s*=\s*"?invalid_token"?`)
+
 type (
+	// ReauthorizePolicy reports whether the response rejects the bearer token.
+	// OAuth middleware then invalidates the token and replays the request once with a new token.
+	ReauthorizePolicy func(resp *http.Response) bool
+
 	// OAuthConfig is OAuth middleware configuration
 	OAuthConfig struct {
 		AuthServerURL string // URI of oatuh server
+		// IssuerURL is the authorization server issuer. Its metadata (OpenID Connect discovery or RFC 8414)
+		// provides the endpoints and the client authentication method not set explicitly.
+		IssuerURL string
+		// RevocationURL is token revocation endpoint (RFC 7009) used by OAuthService.Close
+		RevocationURL string
+		// RevokeOnClose makes OAuthService.Close revoke the tokens and remove them from TokenStore.
+		// Do not set it when TokenStore is shared by other processes still using the tokens.
+		RevokeOnClose bool
 		ClientID      string // application's Client ID
 		ClientSecret  string // application's Client Secret
 		Scope         string // audience for the token, which is your AP
+		Audience      string // optional audience parameter of the token request
+
+		// GrantType is OAuth 2.0 grant type (default GrantTypeClientCredentials).
+		// A refresh token issued by the server is used to refresh the token with any grant type.
+		GrantType     string
+		RefreshToken  string              // refresh token of GrantTypeRefreshToken
+		Username      string              // resource owner username of GrantTypePassword
+		Password      string              // resource owner password of GrantTypePassword
+		TokenExchange TokenExchangeConfig // parameters of GrantTypeTokenExchange
+		// Assertion returns JWT assertion of GrantTypeJWTBearer.
+		// If Assertion is nil, the assertion is signed by PrivateKey with Subject (default ClientID) as subject.
+		Assertion func() (string, error)
+		Subject   string
+
+		// AuthorizationURL is authorization endpoint of GrantTypeAuthorizationCode
+		AuthorizationURL string
+		// RedirectURL is loopback redirect URI of GrantTypeAuthorizationCode listened for the authorization code
+		// (default http://127.0.0.1:0/callback, port 0 selects any free port)
+		RedirectURL string
+		// OpenBrowser shows authorization URL of GrantTypeAuthorizationCode to the user (default prints it to stderr)
+		OpenBrowser func(authURL string) error
+		// DeviceAuthorizationURL is device authorization endpoint of GrantTypeDeviceCode
+		DeviceAuthorizationURL string
+		// DeviceCodePrompt shows user code of GrantTypeDeviceCode to the user (default prints it to stderr)
+		DeviceCodePrompt func(auth DeviceAuthorization) error
+		// UserAuthTimeout limits waiting for the user to authorize the client (default 5m)
+		UserAuthTimeout time.Duration
+
+		// AuthMethod is client authentication method at the token endpoint (default AuthMethodClientSecretPost)
+		AuthMethod string
+		PrivateKey crypto.Signer // key signing JWT of AuthMethodPrivateKeyJWT and GrantTypeJWTBearer
+		KeyID      string        // optional kid header of signed JWT
+
+		// DefaultTokenLifetime is the lifetime of the token without expires_in and JWT exp claim (default 1h)
+		DefaultTokenLifetime time.Duration
+		// RefreshBefore is how long before expiration the token is refreshed,
+		// at most half of the token lifetime (default 30s)
+		RefreshBefore time.Duration
+		// BackgroundRefresh makes requests use the current token while it is refreshed in background
+		BackgroundRefresh bool
+		// ErrorBackoff is the delay before the next token request after a failure,
+		// doubled on each consecutive failure up to 1 minute (default 1s)
+		ErrorBackoff time.Duration
+		// ReauthorizePolicy specifies responses rejecting the token (default DefaultReauthorizePolicy)
+		ReauthorizePolicy ReauthorizePolicy
+		// TokenStore keeps tokens between restarts and processes (default NewMemoryTokenStore).
+		// Failing TokenStore does not fail token requests.
+		TokenStore TokenStore
+
+		// DPoP binds tokens to DPoPKey sending DPoP proofs (RFC 9449) to the token endpoint and with each request.
+		// If DPoPKey is nil, ECDSA P-256 key is generated, so tokens of TokenStore are not usable after restart.
+		DPoP    bool
+		DPoPKey crypto.Signer // key of DPoP proofs, setting it enables DPoP
+
+		dpop *dpopProver
 	}
 
 	// BearerResponse is response from OAuth server
@@ -26,87 +107,402 @@ type (
 		TokenType    string `json:"token_type"`
 		ExpiresIn    int    `json:"expires_in"`
 		RefreshToken string `json:"refresh_token"`
+		MACKey       string `json:"mac_key"`       // MAC key of TokenTypeMAC
+		MACAlgorithm string `json:"mac_algorithm"` // MAC algorithm of TokenTypeMAC
 	}
 
 	// BearerToken is a bearer token model
 	BearerToken struct {
-		AccessToken         string
-		ExpirationTokenTime time.Time
+		AccessToken         string    `json:"access_token"`
+		TokenType           string    `json:"token_type,omitempty"`
+		ExpirationTokenTime time.Time `json:"expires_at"`
+		RefreshToken        string    `json:"refresh_token,omitempty"`
+		MACKey              string    `json:"mac_key,omitempty"`
+		MACAlgorithm        string    `json:"mac_algorithm,omitempty"`
 	}
 
 	// OAuthService is oauth related logic implementation
 	OAuthService struct {
 		client *http.Client
 		config OAuthConfig
-		lock   sync.RWMutex
-		token  *BearerToken
+		store  TokenStore
+
+		mu      sync.Mutex
+		entries map[TokenKey]*tokenEntry
+		// metadata is discovered metadata of config.IssuerURL
+		metadata   *AuthServerMetadata
+		metadataAt time.Time
+	}
+
+	// tokenEntry is the token state of a scope and audience
+	tokenEntry struct {
+		key       TokenKey
+		token     *BearerToken
+		refreshAt time.Time   // time the token is refreshed ahead of expiration
+		fetch     *tokenFetch // in-flight token request shared by concurrent callers
+		failures  int         // number of consecutive failed token requests
+		retryAt   time.Time   // no token requests until this time after a failure
+		lastErr   error
+		// refreshToken is the last refresh token issued, it survives token invalidation
+		refreshToken string
+	}
+
+	// tokenFetch is a single token request awaited by concurrent callers
+	tokenFetch struct {
+		done  chan struct{}
+		token *BearerToken
+		err   error
+		// ctx of the token request is canceled when all waiting callers are gone
+		ctx     context.Context
+		cancel  context.CancelFunc
+		waiters int
 	}
 )
 
-// getBearerToken makes http call to oauth server
-func getBearerToken(cl *http.Client, c OAuthConfig) (*BearerToken, error) {
-	data := url.Values{}
-	data.Set("grant_type", "client_credentials")
-	data.Set("client_id", c.ClientID)
-	if c.ClientSecret != "" {
-		data.Set("client_secret", c.ClientSecret)
+type oauthScopeKey struct{}
+
+// WithOAuthScope makes OAuth middleware authorize a single request with the token
+// of the scope and audience instead of the configured ones.
+func WithOAuthScope(scope, audience string) client.RequestOption {
+	return client.WithContextValue(oauthScopeKey{}, TokenKey{Scope: scope, Audience: audience})
+}
+
+// DefaultReauthorizePolicy reports 401 Unauthorized and invalid_token Bearer challenge as rejected token.
+func DefaultReauthorizePolicy(resp *http.Response) bool {
+	return ReauthorizeOnStatus(http.StatusUnauthorized)(resp)
+}
+
+// ReauthorizeOnStatus creates ReauthorizePolicy reporting responses with one of the status codes
+// or with invalid_token Bearer challenge in WWW-Authenticate header as rejected token.
+func ReauthorizeOnStatus(codes ...int) ReauthorizePolicy {
+	return func(resp *http.Response) bool {
+		for _, code := range codes {
+			if resp.StatusCode == code {
+				return true
+			}
+		}
+		for _, challenge := range resp.Header.Values("WWW-Authenticate") {
+			if invalidTokenChallenge.MatchString(challenge) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// getBearerToken makes http call to oauth server, ctx cancels the token request.
+// If refreshToken is not empty, the token is requested with refresh_token grant.
+func getBearerToken(ctx context.Context, cl *http.Client, c OAuthConfig, refreshToken string) (*BearerToken, error) {
+	if refreshToken == "" {
+		switch c.GrantType {
+		case GrantTypeAuthorizationCode:
+			return authorizationCodeToken(ctx, cl, c)
+		case GrantTypeDeviceCode:
+			return deviceCodeToken(ctx, cl, c)
+		}
+	}
+	data, err := grantForm(c, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return requestToken(ctx, cl, c, data)
+}
+
+// requestToken makes token request with the grant form authenticating the client
+// With DPoP the request is sent with a proof and repeated once if the server demands DPoP nonce.
+func requestToken(ctx context.Context, cl *http.Client, c OAuthConfig, data url.Values) (*BearerToken, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newFormRequest(ctx, c, c.AuthServerURL, data)
+		if err != nil {
+			return nil, err
+		}
+		if c.dpop != nil {
+			proof, err := c.dpop.proof(req.Method, req.URL, "")
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set(DPoPHeader, proof)
+		}
+		res, err := cl.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var tokenObj BearerResponse
+		err = asOAuthError(client.ReadResponse(res, &tokenObj))
+		if c.dpop != nil && c.dpop.updateNonce(req.URL, res) && attempt == 0 && oauthErrorCode(err) == "use_dpop_nonce" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return tokenObj.token(time.Now(), c.DefaultTokenLifetime)
 	}
-	if c.Scope != "" {
-		data.Set("scope", c.Scope)
+}
+
+// postForm makes POST request with the form authenticating the client and reads the response.
+// OAuth error response is returned as OAuthError.
+func postForm(ctx context.Context, cl *http.Client, c OAuthConfig, uri string, data url.Values, response interface{}) error {
+	req, err := newFormRequest(ctx, c, uri, data)
+	if err != nil {
+		return err
+	}
+	res, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+	return asOAuthError(client.ReadResponse(res, response))
+}
+
+// newFormRequest creates POST request with the form authenticating the client
+func newFormRequest(ctx context.Context, c OAuthConfig, uri string, data url.Values) (*http.Request, error) {
+	header := make(http.Header)
+	if err := authenticateClient(c, data, header); err != nil {
+		return nil, err
 	}
 	encodedData := data.Encode()
 	payload := strings.NewReader(encodedData)
 
-	req, err := http.NewRequest("POST", c.AuthServerURL, payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", uri, payload)
 	if err != nil {
 		return nil, err
 	}
+	req.Header = header
 	req.Header.Add("content-type", "application/x-www-form-urlencoded")
-	res, err := cl.Do(req)
+	return req, nil
+}
+
+// GetToken returns the access token requesting a new one if the token is expired
+// or due to refresh. Concurrent callers share a single token request.
+func (s *OAuthService) GetToken() (string, error) {
+	t, err := s.getToken(context.Background())
 	if err != nil {
+		return "", err
+	}
+	return t.AccessToken, nil
+}
+
+// GetScopedToken returns the access token of the scope and audience instead of the configured ones.
+func (s *OAuthService) GetScopedToken(scope, audience string) (string, error) {
+	ctx := context.WithValue(context.Background(), oauthScopeKey{}, TokenKey{Scope: scope, Audience: audience})
+	t, err := s.getToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	return t.AccessToken, nil
+}
+
+// getToken returns valid token, ctx cancels waiting for the token request
+func (s *OAuthService) getToken(ctx context.Context) (*BearerToken, error) {
+	now := time.Now()
+	s.mu.Lock()
+	e := s.entry(s.tokenKey(ctx))
+	t := e.token
+	if t != nil && now.Before(e.refreshAt) {
+		s.mu.Unlock()
+		return t, nil
+	}
+	valid := t != nil && now.Before(t.ExpirationTokenTime)
+	if now.Before(e.retryAt) {
+		err := e.lastErr
+		s.mu.Unlock()
+		if valid {
+			return t, nil
+		}
 		return nil, err
 	}
-	var tokenObj BearerResponse
-	err = client.ReadResponse(res, &tokenObj)
+	f := s.startFetch(e)
+	if valid && s.config.BackgroundRefresh {
+		s.mu.Unlock()
+		return t, nil
+	}
+	f.waiters++
+	s.mu.Unlock()
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		s.mu.Lock()
+		if f.waiters--; f.waiters == 0 {
+			f.cancel()
+		}
+		s.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		if valid {
+			return t, nil
+		}
+		return nil, f.err
+	}
+	return f.token, nil
+}
+
+// tokenKey returns key of the token requested by ctx
+func (s *OAuthService) tokenKey(ctx context.Context) TokenKey {
+	key := TokenKey{ClientID: s.config.ClientID, Scope: s.config.Scope, Audience: s.config.Audience}
+	if scope, ok := ctx.Value(oauthScopeKey{}).(TokenKey); ok {
+		key.Scope = scope.Scope
+		key.Audience = scope.Audience
+	}
+	return key
+}
+
+// entry returns token state of the key, s.mu must be held
+func (s *OAuthService) entry(key TokenKey) *tokenEntry {
+	e, ok := s.entries[key]
+	if !ok {
+		e = &tokenEntry{key: key, refreshToken: s.config.RefreshToken}
+		s.entries[key] = e
+	}
+	return e
+}
+
+// startFetch starts token request unless one is in flight, s.mu must be held
+func (s *OAuthService) startFetch(e *tokenEntry) *tokenFetch {
+	if e.fetch == nil {
+		e.fetch = &tokenFetch{done: make(chan struct{})}
+		e.fetch.ctx, e.fetch.cancel = context.WithCancel(context.Background())
+		go s.refresh(e, e.fetch, e.refreshToken)
+	}
+	return e.fetch
+}
+
+// refresh gets a new token from the store or the server and stores the result
+func (s *OAuthService) refresh(e *tokenEntry, f *tokenFetch, refreshToken string) {
+	defer close(f.done)
+	defer f.cancel()
+	// the token can be refreshed by another process sharing the store
+	if t, err := s.store.Get(e.key); err == nil && t != nil {
+		if time.Until(t.ExpirationTokenTime) > s.config.RefreshBefore {
+			f.token = t
+		} else if t.RefreshToken != "" {
+			refreshToken = t.RefreshToken
+		}
+	}
+	if f.token == nil {
+		f.token, f.err = s.requestToken(f.ctx, e.key, refreshToken)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.fetch = nil
+	now := time.Now()
+	if f.err != nil && f.ctx.Err() != nil {
+		// the request was canceled by the callers, it is not a failure of the server
+		return
+	}
+	if f.err != nil {
+		e.failures++
+		e.lastErr = f.err
+		e.retryAt = now.Add(s.backoff(e.failures))
+		return
+	}
+	e.failures = 0
+	e.lastErr = nil
+	e.retryAt = time.Time{}
+	e.refreshToken = f.token.RefreshToken
+	e.token = f.token
+	before := s.config.RefreshBefore
+	if lifetime := f.token.ExpirationTokenTime.Sub(now); before > lifetime/2 {
+		before = lifetime / 2
+	}
+	e.refreshAt = f.token.ExpirationTokenTime.Add(-before)
+}
+
+// requestToken requests a new token of the key from the server and puts it to the store
+func (s *OAuthService) requestToken(ctx context.Context, key TokenKey, refreshToken string) (*BearerToken, error) {
+	c, err := s.resolveConfig(ctx)
 	if err != nil {
 		return nil, err
 	}
-	exptime := time.Now().Add(time.Second * time.Duration(tokenObj.ExpiresIn))
-	return &BearerToken{
-		AccessToken:         tokenObj.AccessToken,
-		ExpirationTokenTime: exptime,
-	}, nil
+	c.Scope = key.Scope
+	c.Audience = key.Audience
+	t, err := getBearerToken(ctx, s.client, c, refreshToken)
+	if err != nil && ctx.Err() == nil && refreshToken != "" && c.GrantType != GrantTypeRefreshToken {
+		// refresh token can be expired or revoked, request a new token with the grant
+		refreshToken = ""
+		t, err = getBearerToken(ctx, s.client, c, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.RefreshToken == "" {
+		// the server may keep the refresh token (RFC 6749 section 6)
+		t.RefreshToken = refreshToken
+	}
+	_ = s.store.Put(key, t)
+	return t, nil
 }
 
-// GetToken checks if token is expired the request it in thread safe mode
-func (s *OAuthService) GetToken() (string, error) {
-	s.lock.RLock()
-	token := ""
-	if s.token != nil && time.Now().Before(s.token.ExpirationTokenTime) {
-		token = s.token.AccessToken
-	}
-	s.lock.RUnlock()
-	if token == "" {
-		s.lock.Lock()
-		t, err := getBearerToken(s.client, s.config)
-		if err != nil {
-			return "", err
+// backoff returns delay before the next token request after consecutive failures
+func (s *OAuthService) backoff(failures int) time.Duration {
+	d := s.config.ErrorBackoff
+	for i := 1; i < failures && d < maxOAuthErrorBackoff; i++ {
+		d *= 2
+	}
+	if d > maxOAuthErrorBackoff {
+		d = maxOAuthErrorBackoff
+	}
+	return d
+}
+
+// InvalidateToken drops the cached token if it is the access token,
+// so the next GetToken requests a new one.
+func (s *OAuthService) InvalidateToken(accessToken string) {
+	var keys []TokenKey
+	s.mu.Lock()
+	for key, e := range s.entries {
+		if e.token != nil && e.token.AccessToken == accessToken {
+			e.token = nil
+			e.refreshAt = time.Time{}
+			keys = append(keys, key)
 		}
-		s.token = t
-		token = t.AccessToken
-		s.lock.Unlock()
 	}
-	return token, nil
+	s.mu.Unlock()
+	for _, key := range keys {
+		_ = s.store.Invalidate(key)
+	}
 }
 
 // AddAuthorizationHeader adds authorization header to http.Request
 func (s *OAuthService) AddAuthorizationHeader(request *http.Request) error {
-	t, err := s.GetToken()
+	_, err := s.authorize(request)
+	return err
+}
+
+// authorize adds authorization header to http.Request and returns the token used
+func (s *OAuthService) authorize(request *http.Request) (*BearerToken, error) {
+	t, err := s.getToken(request.Context())
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err = s.setAuthorization(request, t); err != nil {
+		return nil, err
 	}
-	request.Header.Add("authorization", fmt.Sprintf("Bearer %s", t))
+	return t, nil
+}
+
+// reauthorize replaces rejected token of the request with a new one.
+// It returns false if there is no other token to replay the request with.
+func (s *OAuthService) reauthorize(request *http.Request, rejected *BearerToken) bool {
+	s.InvalidateToken(rejected.AccessToken)
+	t, err := s.getToken(request.Context())
+	if err != nil || t.AccessToken == rejected.AccessToken {
+		return false
+	}
+	return s.setAuthorization(request, t) == nil
+}
 
+// setAuthorization sets Authorization header of the token and DPoP proof of DPoP token
+func (s *OAuthService) setAuthorization(request *http.Request, t *BearerToken) error {
+	authorization, err := t.authorization(request)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", authorization)
+	if s.config.dpop != nil {
+		return s.config.dpop.sign(request)
+	}
 	return nil
 }
 
@@ -119,9 +515,27 @@ func NewOAuthService(c OAuthConfig, cl *http.Client) OAuthService {
 		clnt.Use(Retry())
 		cl = clnt.Client
 	}
+	if c.RefreshBefore <= 0 {
+		c.RefreshBefore = defaultOAuthRefreshBefore
+	}
+	if c.ErrorBackoff <= 0 {
+		c.ErrorBackoff = defaultOAuthErrorBackoff
+	}
+	if c.ReauthorizePolicy == nil {
+		c.ReauthorizePolicy = DefaultReauthorizePolicy
+	}
+	store := c.TokenStore
+	if store == nil {
+		store = NewMemoryTokenStore()
+	}
+	if c.DPoP || c.DPoPKey != nil {
+		c.dpop = newDPoPProver(c.DPoPKey)
+	}
 	return OAuthService{
-		client: cl,
-		config: c,
+		client:  cl,
+		config:  c,
+		store:   store,
+		entries: map[TokenKey]*tokenEntry{},
 	}
 }
 
@@ -130,15 +544,59 @@ func OAuth(c OAuthConfig) client.MiddlewareFunc {
 	return OAuthWithClient(c, nil)
 }
 
-// OAuthWithClient adds Bearer token authentication to requests
+// OAuthWithClient adds Bearer token authentication to requests.
+// The request rejected by ReauthorizePolicy is replayed once with a new token.
 func OAuthWithClient(c OAuthConfig, cl *http.Client) client.MiddlewareFunc {
 	s := NewOAuthService(c, cl)
-	return func(c *http.Client, next client.Responder) client.Responder {
+	return s.Middleware()
+}
+
+// Middleware returns OAuth middleware authorizing requests with tokens of the service.
+// The request rejected by ReauthorizePolicy is replayed once with a new token.
+// With DPoP each request and each attempt of Retry middleware is sent with a fresh proof,
+// the request rejected for missing DPoP nonce is replayed once with the nonce.
+func (s *OAuthService) Middleware() client.MiddlewareFunc {
+	return client.Named(OAuthMiddlewareName, func(c *http.Client, next client.Responder) client.Responder {
 		return func(request *http.Request) (*http.Response, error) {
-			if err := s.AddAuthorizationHeader(request); err != nil {
+			if p := s.config.dpop; p != nil {
+				request = request.WithContext(withAttemptHook(request.Context(), func(r *http.Request) (*http.Request, func(*http.Response, error), error) {
+					if AttemptFromContext(r.Context()) == 1 {
+						return r, nil, nil
+					}
+					return r, nil, p.sign(r)
+				}))
+			}
+			req, err := client.FromRequest(request)
+			if err != nil {
 				return nil, err
 			}
-			return next(request)
+			if err = req.RewindBody(); err != nil {
+				return nil, err
+			}
+			t, err := s.authorize(request)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := next(request)
+			if err == nil && s.dpopNonceRequired(request, resp) {
+				resp, err = replay(req, resp, next)
+			}
+			if err != nil || !s.config.ReauthorizePolicy(resp) || !s.reauthorize(request, t) {
+				return resp, err
+			}
+			return replay(req, resp, next)
 		}
+	})
+}
+
+// replay sends the request again draining the response.
+// The response is returned if the request body can't be rewound.
+func replay(req *client.Request, resp *http.Response, next client.Responder) (*http.Response, error) {
+	if err := req.RewindBody(); err != nil {
+		return resp, nil
+	}
+	if resp.Body != nil {
+		drainBody(resp.Body)
 	}
+	return next(req.Request)
 }
@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shuvava/go-enrichable-client/client"
+)
+
+// discoveryTTL is how long discovered metadata of the issuer is cached by OAuthService
+const discoveryTTL = time.Hour
+
+// AuthServerMetadata is authorization server metadata (RFC 8414 section 2, OpenID Connect Discovery 1.0)
+type AuthServerMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+// DiscoverAuthServer fetches metadata of the issuer from OpenID Connect discovery endpoint
+// /.well-known/openid-configuration falling back to RFC 8414 endpoint /.well-known/oauth-authorization-server.
+func DiscoverAuthServer(ctx context.Context, cl *http.Client, issuer string) (*AuthServerMetadata, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return nil, err
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	oidc, rfc8414 := *u, *u
+	oidc.Path = path + "/.well-known/openid-configuration"
+	// RFC 8414 section 3.1 inserts the well-known suffix between the host and the path of the issuer
+	rfc8414.Path = "/.well-known/oauth-authorization-server" + path
+
+	var errs []error
+	for _, uri := range []string{oidc.String(), rfc8414.String()} {
+		var m AuthServerMetadata
+		if err = getMetadata(ctx, cl, uri, &m); err != nil {
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if strings.TrimSuffix(m.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+			return nil, fmt.Errorf("oauth metadata issuer %q does not match %q", m.Issuer, issuer)
+		}
+		return &m, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// getMetadata makes GET request of the metadata document
+func getMetadata(ctx context.Context, cl *http.Client, uri string, m *AuthServerMetadata) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", client.ContentTypeJSON)
+	res, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+	return client.ReadResponse(res, m)
+}
+
+// authMethod returns client authentication method supported by the server for the config
+func (m *AuthServerMetadata) authMethod(c OAuthConfig) string {
+	supported := m.TokenEndpointAuthMethodsSupported
+	if len(supported) == 0 {
+		// the default of RFC 8414 section 2
+		supported = []string{AuthMethodClientSecretBasic}
+	}
+	has := func(method string) bool {
+		for _, s := range supported {
+			if s == method {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case c.PrivateKey != nil && has(AuthMethodPrivateKeyJWT):
+		return AuthMethodPrivateKeyJWT
+	case c.ClientSecret != "" && !has(AuthMethodClientSecretPost) && has(AuthMethodClientSecretBasic):
+		return AuthMethodClientSecretBasic
+	}
+	return AuthMethodClientSecretPost
+}
+
+// resolveConfig returns the config with endpoints and client authentication method
+// discovered from IssuerURL metadata
+func (s *OAuthService) resolveConfig(ctx context.Context) (OAuthConfig, error) {
+	c := s.config
+	if c.IssuerURL == "" {
+		return c, nil
+	}
+	s.mu.Lock()
+	m := s.metadata
+	stale := time.Since(s.metadataAt) > discoveryTTL
+	s.mu.Unlock()
+	if m == nil || stale {
+		discovered, err := DiscoverAuthServer(ctx, s.client, c.IssuerURL)
+		if err != nil && m == nil {
+			return c, err
+		}
+		if err == nil {
+			m = discovered
+			s.mu.Lock()
+			s.metadata, s.metadataAt = m, time.Now()
+			s.mu.Unlock()
+		}
+	}
+	c.AuthServerURL = valueOrDefault(c.AuthServerURL, m.TokenEndpoint)
+	c.AuthorizationURL = valueOrDefault(c.AuthorizationURL, m.AuthorizationEndpoint)
+	c.DeviceAuthorizationURL = valueOrDefault(c.DeviceAuthorizationURL, m.DeviceAuthorizationEndpoint)
+	c.RevocationURL = valueOrDefault(c.RevocationURL, m.RevocationEndpoint)
+	c.AuthMethod = valueOrDefault(c.AuthMethod, m.authMethod(c))
+	return c, nil
+}
+
+// Close drops tokens of the service. With RevokeOnClose the tokens are revoked at the revocation
+// endpoint (RFC 7009) and removed from TokenStore, if the revocation endpoint is unknown, the tokens are only removed.
+// Tokens of TokenStore are left intact without RevokeOnClose, so other processes sharing the store keep using them.
+func (s *OAuthService) Close() error {
+	var tokens []tokenEntry
+	s.mu.Lock()
+	for _, e := range s.entries {
+		if e.token != nil || e.refreshToken != "" {
+			tokens = append(tokens, tokenEntry{key: e.key, token: e.token, refreshToken: e.refreshToken})
+		}
+		e.token = nil
+		e.refreshToken = ""
+		e.refreshAt = time.Time{}
+	}
+	s.mu.Unlock()
+	if len(tokens) == 0 || !s.config.RevokeOnClose {
+		return nil
+	}
+
+	c, err := s.resolveConfig(context.Background())
+	errs := []error{err}
+	for _, e := range tokens {
+		errs = append(errs, s.store.Invalidate(e.key))
+		if c.RevocationURL == "" {
+			continue
+		}
+		// revoking refresh token invalidates access tokens issued with it on most servers,
+		// but RFC 7009 section 2.1 does not require it
+		if e.refreshToken != "" {
+			errs = append(errs, revokeToken(s.client, c, e.refreshToken, "refresh_token"))
+		}
+		if e.token != nil {
+			errs = append(errs, revokeToken(s.client, c, e.token.AccessToken, "access_token"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// revokeToken makes token revocation request (RFC 7009 section 2.1)
+func revokeToken(cl *http.Client, c OAuthConfig, token, hint string) error {
+	req, err := newFormRequest(context.Background(), c, c.RevocationURL, url.Values{
+		"token":           {token},
+		"token_type_hint": {hint},
+	})
+	if err != nil {
+		return err
+	}
+	res, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer client.DrainBody(res.Body)
+	return client.AssertStatusCode(res)
+}
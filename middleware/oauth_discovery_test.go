@@ -0,0 +1,171 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/shuvava/go-enrichable-client/middleware"
+)
+
+// issuedTokens is token response of the issuer with a refresh token and without expires_in
+func issuedTokens(url.Values) (int, string) {
+	return http.StatusOK, `{"token_type":"Bearer","access_token":"123","refresh_token":"r1"}`
+}
+
+// publishMetadata adds metadata endpoint at metadataPath and revocation endpoint at /revoke to the server
+func (s *authServer) publishMetadata(metadataPath, issuerPath string, authMethods []string) {
+	s.handle(metadataPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(middleware.AuthServerMetadata{
+			Issuer:                            s.URL + issuerPath,
+			TokenEndpoint:                     s.URL + "/token",
+			RevocationEndpoint:                s.URL + "/revoke",
+			DeviceAuthorizationEndpoint:       s.URL + "/device",
+			TokenEndpointAuthMethodsSupported: authMethods,
+		})
+	})
+	s.handle("/revoke", func(http.ResponseWriter, *http.Request) {})
+}
+
+// revocations returns forms of revocation requests
+func (s *authServer) revocations() []url.Values {
+	var forms []url.Values
+	for _, r := range s.requestsTo("/revoke") {
+		forms = append(forms, r.PostForm)
+	}
+	return forms
+}
+
+func TestDiscoverAuthServer(t *testing.T) {
+	t.Run("Should discover OpenID Connect configuration", func(t *testing.T) {
+		server := newAuthServer(t, issuedTokens)
+		server.publishMetadata("/tenant/.well-known/openid-configuration", "/tenant", nil)
+
+		m, err := middleware.DiscoverAuthServer(context.Background(), server.Client(), server.URL+"/tenant")
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if m.TokenEndpoint != server.URL+"/token" || m.RevocationEndpoint != server.URL+"/revoke" {
+			t.Errorf("metadata got %+v", m)
+		}
+	})
+	t.Run("Should fall back to RFC 8414 metadata", func(t *testing.T) {
+		server := newAuthServer(t, issuedTokens)
+		server.publishMetadata("/.well-known/oauth-authorization-server/tenant", "/tenant", nil)
+
+		m, err := middleware.DiscoverAuthServer(context.Background(), server.Client(), server.URL+"/tenant")
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if m.TokenEndpoint != server.URL+"/token" {
+			t.Errorf("metadata got %+v", m)
+		}
+	})
+	t.Run("Should stop discovery when context is canceled", func(t *testing.T) {
+		server := newAuthServer(t, issuedTokens)
+		server.publishMetadata("/.well-known/openid-configuration", "", nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := middleware.DiscoverAuthServer(ctx, server.Client(), server.URL); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled but got %v", err)
+		}
+		if n := len(server.requestsTo("/.well-known/openid-configuration")); n != 0 {
+			t.Errorf("discoveries got %d, expected %d", n, 0)
+		}
+	})
+	t.Run("Should reject metadata of another issuer", func(t *testing.T) {
+		server := newAuthServer(t, issuedTokens)
+		server.publishMetadata("/.well-known/openid-configuration", "/other", nil)
+
+		if _, err := middleware.DiscoverAuthServer(context.Background(), server.Client(), server.URL); err == nil {
+			t.Errorf("error should be returned")
+		}
+	})
+}
+
+func TestOAuthDiscovery(t *testing.T) {
+	t.Run("Should request token at discovered endpoint", func(t *testing.T) {
+		server := newAuthServer(t, issuedTokens)
+		server.publishMetadata("/.well-known/openid-configuration", "", []string{middleware.AuthMethodClientSecretBasic})
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			IssuerURL:            server.URL,
+			ClientID:             "1",
+			ClientSecret:         "2",
+			DefaultTokenLifetime: time.Millisecond,
+		}, server.Client())
+
+		for i := 0; i < 2; i++ {
+			time.Sleep(5 * time.Millisecond)
+			token, err := svc.GetToken()
+			if err != nil || token != "123" {
+				t.Fatalf("token got '%s' %v, want '%s'", token, err, "123")
+			}
+		}
+		discoveries := server.requestsTo("/.well-known/openid-configuration")
+		if len(server.requests) != 2 || len(discoveries) != 1 {
+			t.Errorf("token requests got %d, discoveries got %d", len(server.requests), len(discoveries))
+		}
+		if id, secret, ok := server.requests[0].BasicAuth(); !ok || id != "1" || secret != "2" {
+			t.Errorf("basic auth got %q %q %v", id, secret, ok)
+		}
+	})
+	t.Run("Should revoke tokens on close", func(t *testing.T) {
+		server := newAuthServer(t, issuedTokens)
+		server.publishMetadata("/.well-known/openid-configuration", "", nil)
+		store := middleware.NewMemoryTokenStore()
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			IssuerURL:     server.URL,
+			ClientID:      "1",
+			TokenStore:    store,
+			RevokeOnClose: true,
+		}, server.Client())
+
+		if _, err := svc.GetToken(); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if err := svc.Close(); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		revocations := server.revocations()
+		if len(revocations) != 2 {
+			t.Fatalf("revocations got %d, expected %d", len(revocations), 2)
+		}
+		assertForm(t, revocations[0], map[string]string{"token": "r1", "token_type_hint": "refresh_token", "client_id": "1"})
+		assertForm(t, revocations[1], map[string]string{"token": "123", "token_type_hint": "access_token"})
+		if stored, _ := store.Get(middleware.TokenKey{ClientID: "1"}); stored != nil {
+			t.Errorf("token should be removed from store, got %v", stored)
+		}
+		if err := svc.Close(); err != nil || len(server.revocations()) != 2 {
+			t.Errorf("second close should not revoke tokens, got %v", err)
+		}
+	})
+	t.Run("Should keep tokens of shared store on close", func(t *testing.T) {
+		server := newAuthServer(t, issuedTokens)
+		server.publishMetadata("/.well-known/openid-configuration", "", nil)
+		store := middleware.NewMemoryTokenStore()
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			IssuerURL:  server.URL,
+			ClientID:   "1",
+			TokenStore: store,
+		}, server.Client())
+
+		if _, err := svc.GetToken(); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if err := svc.Close(); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if revocations := server.revocations(); len(revocations) != 0 {
+			t.Errorf("revocations got %d, expected %d", len(revocations), 0)
+		}
+		if stored, _ := store.Get(middleware.TokenKey{ClientID: "1"}); stored == nil {
+			t.Errorf("token should be kept in store")
+		}
+	})
+}
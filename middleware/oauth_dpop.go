@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// These constants are DPoP (RFC 9449) header names.
+const (
+	DPoPHeader      = "DPoP"
+	DPoPNonceHeader = "DPoP-Nonce"
+)
+
+// useDPoPNonceChallenge matches WWW-Authenticate challenge requiring DPoP nonce (RFC 9449 section 9)
+var useDPoPNonceChallenge = regexp.MustCompile(`(?i)\bdpop\b.*\berror\s*=\s*"?use_dpop_nonce"?`)
+
+type (
+	// dpopProver signs DPoP proofs (RFC 9449 section 4) with the key bound to the tokens
+	dpopProver struct {
+		key crypto.Signer
+		jwk map[string]string
+		err error // error of the key, returned by each proof
+
+		mu     sync.Mutex
+		nonces map[string]string // the last DPoP-Nonce by origin of the server
+	}
+
+	// dpopClaims is payload of DPoP proof JWT (RFC 9449 section 4.2)
+	dpopClaims struct {
+		JTI   string `json:"jti"`
+		HTM   string `json:"htm"`
+		HTU   string `json:"htu"`
+		IAT   int64  `json:"iat"`
+		ATH   string `json:"ath,omitempty"`
+		Nonce string `json:"nonce,omitempty"`
+	}
+)
+
+// newDPoPProver creates dpopProver of the key generating ECDSA P-256 key if it is nil
+func newDPoPProver(key crypto.Signer) *dpopProver {
+	p := &dpopProver{key: key, nonces: map[string]string{}}
+	if p.key == nil {
+		p.key, p.err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if p.err != nil {
+			return p
+		}
+	}
+	if _, _, p.err = jwtAlgorithm(p.key); p.err == nil {
+		p.jwk, p.err = publicJWK(p.key.Public())
+	}
+	return p
+}
+
+// publicJWK returns JWK (RFC 7517) of the public key
+func publicJWK(pub crypto.PublicKey) (map[string]string, error) {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "n": enc(pub.N.Bytes()), "e": enc(big.NewInt(int64(pub.E)).Bytes())}, nil
+	case *ecdsa.PublicKey:
+		k, err := pub.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		// uncompressed point 0x04 || X || Y
+		point := k.Bytes()[1:]
+		size := len(point) / 2
+		return map[string]string{"kty": "EC", "crv": pub.Curve.Params().Name, "x": enc(point[:size]), "y": enc(point[size:])}, nil
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "crv": "Ed25519", "x": enc(pub)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported dpop key %T", pub)
+	}
+}
+
+// proof returns DPoP proof of the request with the access token hash if the token is not empty
+func (p *dpopProver) proof(method string, u *url.URL, accessToken string) (string, error) {
+	if p.err != nil {
+		return "", p.err
+	}
+	htu := url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path, RawPath: u.RawPath}
+	claims := dpopClaims{
+		JTI:   newJTI(),
+		HTM:   method,
+		HTU:   htu.String(),
+		IAT:   time.Now().Unix(),
+		Nonce: p.nonce(u),
+	}
+	if accessToken != "" {
+		ath := sha256.Sum256([]byte(accessToken))
+		claims.ATH = base64.RawURLEncoding.EncodeToString(ath[:])
+	}
+	return signJWT(p.key, map[string]interface{}{"typ": "dpop+jwt", "jwk": p.jwk}, claims)
+}
+
+// sign sets DPoP header of the request with a fresh proof.
+// The proof is bound to the access token of DPoP Authorization header, a request without it
+// is sent without the proof.
+func (p *dpopProver) sign(request *http.Request) error {
+	accessToken, ok := strings.CutPrefix(request.Header.Get("Authorization"), TokenTypeDPoP+" ")
+	if !ok {
+		request.Header.Del(DPoPHeader)
+		return nil
+	}
+	proof, err := p.proof(request.Method, request.URL, accessToken)
+	if err != nil {
+		return err
+	}
+	request.Header.Set(DPoPHeader, proof)
+	return nil
+}
+
+// nonce returns the last nonce provided by the server of the URL
+func (p *dpopProver) nonce(u *url.URL) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.nonces[origin(u)]
+}
+
+// updateNonce stores DPoP-Nonce of the response (RFC 9449 section 8.2) and reports whether it is present
+func (p *dpopProver) updateNonce(u *url.URL, resp *http.Response) bool {
+	nonce := resp.Header.Get(DPoPNonceHeader)
+	if nonce == "" {
+		return false
+	}
+	p.mu.Lock()
+	p.nonces[origin(u)] = nonce
+	p.mu.Unlock()
+	return true
+}
+
+// origin returns scheme and host of the URL
+func origin(u *url.URL) string {
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// dpopNonceRequired stores DPoP-Nonce of the response and reports whether the server rejected
+// the proof demanding the nonce (RFC 9449 section 9). The request then has a new proof with the nonce.
+func (s *OAuthService) dpopNonceRequired(request *http.Request, resp *http.Response) bool {
+	p := s.config.dpop
+	if p == nil || !p.updateNonce(request.URL, resp) {
+		return false
+	}
+	if resp.StatusCode != http.StatusUnauthorized || !useDPoPNonceChallenge.MatchString(resp.Header.Get("WWW-Authenticate")) {
+		return false
+	}
+	return p.sign(request) == nil
+}
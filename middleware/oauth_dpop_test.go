@@ -0,0 +1,206 @@
+package middleware_test
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/shuvava/go-enrichable-client/client"
+	"github.com/shuvava/go-enrichable-client/middleware"
+)
+
+// requireDPoPNonce makes token endpoint at /token of the server issue tokens of the type
+// after DPoP nonce is used in the proof
+func (s *authServer) requireDPoPNonce(tokenType string) {
+	s.handle("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(middleware.DPoPNonceHeader, "n1")
+		claims, err := dpopProofClaims(r.Header.Get(middleware.DPoPHeader))
+		if err != nil {
+			s.fail(err)
+			writeJSON(w, http.StatusBadRequest, `{"error":"invalid_dpop_proof"}`)
+			return
+		}
+		if claims["nonce"] != "n1" {
+			writeJSON(w, http.StatusBadRequest, `{"error":"use_dpop_nonce"}`)
+			return
+		}
+		writeJSON(w, http.StatusOK, `{"token_type":"`+tokenType+`","expires_in":3599,"access_token":"123"}`)
+	})
+}
+
+// proofs returns DPoP proofs of token requests at /token
+func (s *authServer) proofs() []string {
+	var proofs []string
+	for _, r := range s.requestsTo("/token") {
+		proofs = append(proofs, r.Header.Get(middleware.DPoPHeader))
+	}
+	return proofs
+}
+
+// parseDPoPProof verifies the proof signature with its jwk header and returns the claims
+func parseDPoPProof(t *testing.T, proof string) map[string]interface{} {
+	t.Helper()
+	claims, err := dpopProofClaims(proof)
+	if err != nil {
+		t.Fatalf("did not expect an error but got one %v", err)
+	}
+	return claims
+}
+
+// dpopProofClaims verifies the proof signature with its jwk header and returns the claims.
+// Unlike parseDPoPProof it can be used by server handlers.
+func dpopProofClaims(proof string) (map[string]interface{}, error) {
+	headerJSON, _ := base64.RawURLEncoding.DecodeString(strings.Split(proof, ".")[0])
+	var header struct {
+		Typ string            `json:"typ"`
+		JWK map[string]string `json:"jwk"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil || header.Typ != "dpop+jwt" {
+		return nil, fmt.Errorf("invalid dpop header %s %v", headerJSON, err)
+	}
+	x, _ := base64.RawURLEncoding.DecodeString(header.JWK["x"])
+	var key crypto.PublicKey
+	switch header.JWK["kty"] {
+	case "EC":
+		y, _ := base64.RawURLEncoding.DecodeString(header.JWK["y"])
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, fmt.Errorf("invalid jwk %v", err)
+		}
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	case "OKP":
+		key = ed25519.PublicKey(x)
+	}
+	return jwtClaims(proof, key)
+}
+
+// accessTokenHash returns ath claim of the access token
+func accessTokenHash(accessToken string) string {
+	h := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func TestOAuthDPoP(t *testing.T) {
+	t.Run("Should request token with DPoP proof and nonce", func(t *testing.T) {
+		server := newAuthServer(t, nil)
+		server.requireDPoPNonce(middleware.TokenTypeDPoP)
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{AuthServerURL: server.URL + "/token?tenant=1", ClientID: "1", DPoP: true}, server.Client())
+
+		token, err := svc.GetToken()
+		if err != nil || token != "123" {
+			t.Fatalf("token got '%s' %v, want '%s'", token, err, "123")
+		}
+		proofs := server.proofs()
+		if len(proofs) != 2 {
+			t.Fatalf("token requests got %d, expected %d", len(proofs), 2)
+		}
+		claims := parseDPoPProof(t, proofs[1])
+		if claims["htm"] != http.MethodPost || claims["htu"] != server.URL+"/token" || claims["ath"] != nil || claims["jti"] == "" {
+			t.Errorf("claims got %v", claims)
+		}
+		if parseDPoPProof(t, proofs[0])["jti"] == claims["jti"] {
+			t.Errorf("jti should not be reused")
+		}
+	})
+	t.Run("Should send DPoP proof with request", func(t *testing.T) {
+		server := newAuthServer(t, nil)
+		server.requireDPoPNonce(middleware.TokenTypeDPoP)
+		_, key, _ := ed25519.GenerateKey(rand.Reader)
+		var requests []*http.Request
+		api := client.NewMockTransport(true)
+		api.RegisterResponder(http.MethodGet, "https://www.example.com/items?id=1", func(request *http.Request) (*http.Response, error) {
+			requests = append(requests, request.Clone(request.Context()))
+			resp := &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: make(http.Header)}
+			if len(requests) == 1 {
+				resp.StatusCode = http.StatusUnauthorized
+				resp.Header.Set("WWW-Authenticate", `DPoP error="use_dpop_nonce", error_description="Resource server requires nonce in DPoP proof"`)
+				resp.Header.Set(middleware.DPoPNonceHeader, "r1")
+			}
+			return resp, nil
+		})
+		richClient := client.NewClient(api)
+		richClient.Use(middleware.OAuthWithClient(middleware.OAuthConfig{AuthServerURL: server.URL + "/token", ClientID: "1", DPoPKey: key}, server.Client()))
+
+		response, err := richClient.Client.Get("https://www.example.com/items?id=1")
+		assertResponse(t, response, err, http.StatusOK, "")
+		if len(requests) != 2 || len(server.proofs()) != 2 {
+			t.Fatalf("requests got %d, token requests got %d", len(requests), len(server.proofs()))
+		}
+		var jti []interface{}
+		for i, nonce := range []interface{}{nil, "r1"} {
+			if got := requests[i].Header.Get("Authorization"); got != "DPoP 123" {
+				t.Errorf("authorization got %q, want %q", got, "DPoP 123")
+			}
+			claims := parseDPoPProof(t, requests[i].Header.Get(middleware.DPoPHeader))
+			if claims["htm"] != http.MethodGet || claims["htu"] != "https://www.example.com/items" ||
+				claims["ath"] != accessTokenHash("123") || claims["nonce"] != nonce {
+				t.Errorf("claims got %v", claims)
+			}
+			jti = append(jti, claims["jti"])
+		}
+		if jti[0] == jti[1] {
+			t.Errorf("jti should not be reused")
+		}
+	})
+	t.Run("Should send fresh DPoP proof with each retry attempt", func(t *testing.T) {
+		server := newAuthServer(t, nil)
+		server.requireDPoPNonce(middleware.TokenTypeDPoP)
+		var proofs []string
+		api := client.NewMockTransport(true)
+		api.RegisterResponder(http.MethodGet, "https://www.example.com", func(request *http.Request) (*http.Response, error) {
+			proofs = append(proofs, request.Header.Get(middleware.DPoPHeader))
+			code := http.StatusOK
+			if len(proofs) < 3 {
+				code = http.StatusServiceUnavailable
+			}
+			return &http.Response{StatusCode: code, Body: http.NoBody, Header: make(http.Header)}, nil
+		})
+		richClient := client.NewClient(api)
+		richClient.Use(
+			middleware.OAuthWithClient(middleware.OAuthConfig{AuthServerURL: server.URL + "/token", ClientID: "1", DPoP: true}, server.Client()),
+			middleware.RetryWithConfig(newRetryConfig()),
+		)
+
+		response, err := richClient.Client.Get("https://www.example.com")
+		assertResponse(t, response, err, http.StatusOK, "")
+		if len(proofs) != 3 {
+			t.Fatalf("attempts got %d, expected %d", len(proofs), 3)
+		}
+		seen := map[interface{}]bool{}
+		for _, proof := range proofs {
+			claims := parseDPoPProof(t, proof)
+			if claims["ath"] != accessTokenHash("123") || seen[claims["jti"]] {
+				t.Errorf("claims got %v", claims)
+			}
+			seen[claims["jti"]] = true
+		}
+	})
+	t.Run("Should not send DPoP proof with bearer token", func(t *testing.T) {
+		server := newAuthServer(t, nil)
+		server.requireDPoPNonce(middleware.TokenTypeBearer)
+		var request *http.Request
+		api := client.NewMockTransport(true)
+		api.RegisterResponder(http.MethodGet, "https://www.example.com", func(r *http.Request) (*http.Response, error) {
+			request = r
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: make(http.Header)}, nil
+		})
+		richClient := client.NewClient(api)
+		richClient.Use(middleware.OAuthWithClient(middleware.OAuthConfig{AuthServerURL: server.URL + "/token", ClientID: "1", DPoP: true}, server.Client()))
+
+		response, err := richClient.Client.Get("https://www.example.com")
+		assertResponse(t, response, err, http.StatusOK, "")
+		if request.Header.Get("Authorization") != "Bearer 123" || request.Header.Get(middleware.DPoPHeader) != "" {
+			t.Errorf("headers got %v", request.Header)
+		}
+	})
+}
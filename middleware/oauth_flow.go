@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// These constants are OAuth 2.0 grant types authorizing the client by the user.
+// The user authorizes the client once, then the token is refreshed with the issued refresh token.
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+const (
+	defaultRedirectURL        = "http://127.0.0.1:0/callback"
+	defaultUserAuthTimeout    = 5 * time.Minute
+	defaultDevicePollInterval = 5 * time.Second
+	devicePollSlowDown        = 5 * time.Second
+)
+
+// ErrUserAuthTimeout is returned when the user does not authorize the client in time.
+var ErrUserAuthTimeout = errors.New("oauth user authorization timed out")
+
+// DeviceAuthorization is response of device authorization endpoint (RFC 8628 section 3.2)
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// authorizationCodeToken requests the token with authorization code grant with PKCE (RFC 7636),
+// receiving the code on the loopback redirect URI (RFC 8252 section 7.3). ctx cancels waiting for the user.
+func authorizationCodeToken(ctx context.Context, cl *http.Client, c OAuthConfig) (*BearerToken, error) {
+	redirect, err := url.Parse(valueOrDefault(c.RedirectURL, defaultRedirectURL))
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(redirect.Hostname()); redirect.Hostname() != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("oauth redirect URL %q is not a loopback address", c.RedirectURL)
+	}
+	ln, err := net.Listen("tcp", redirect.Host)
+	if err != nil {
+		return nil, err
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	redirect.Host = net.JoinHostPort(redirect.Hostname(), port)
+	if redirect.Path == "" {
+		redirect.Path = "/"
+	}
+
+	verifier := randomString(32)
+	challenge := sha256.Sum256([]byte(verifier))
+	state := randomString(16)
+	authURL, err := url.Parse(c.AuthorizationURL)
+	if err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", c.ClientID)
+	query.Set("redirect_uri", redirect.String())
+	query.Set("state", state)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	if c.Scope != "" {
+		query.Set("scope", c.Scope)
+	}
+	if c.Audience != "" {
+		query.Set("audience", c.Audience)
+	}
+	authURL.RawQuery = query.Encode()
+
+	result := make(chan url.Values, 1)
+	srv := &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			if r.URL.Path != redirect.Path || q.Get("state") != state {
+				http.Error(w, "invalid authorization response", http.StatusBadRequest)
+				return
+			}
+			select {
+			case result <- q:
+			default:
+			}
+			if q.Get("error") != "" {
+				_, _ = fmt.Fprint(w, "Authorization failed. You can close this window.")
+				return
+			}
+			_, _ = fmt.Fprint(w, "Authorization completed. You can close this window.")
+		}),
+	}
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	defer srv.Close()
+
+	open := c.OpenBrowser
+	if open == nil {
+		open = printAuthURL
+	}
+	if err = open(authURL.String()); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(userAuthTimeout(c))
+	defer timer.Stop()
+	var q url.Values
+	select {
+	case q = <-result:
+	case <-timer.C:
+		return nil, ErrUserAuthTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if e := q.Get("error"); e != "" {
+		return nil, &OAuthError{Code: e, Description: q.Get("error_description"), URI: q.Get("error_uri")}
+	}
+	return requestToken(ctx, cl, c, url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"code":          {q.Get("code")},
+		"redirect_uri":  {redirect.String()},
+		"code_verifier": {verifier},
+	})
+}
+
+// deviceCodeToken requests the token with device authorization grant (RFC 8628),
+// polling the token endpoint until the user authorizes the device or ctx is canceled
+func deviceCodeToken(ctx context.Context, cl *http.Client, c OAuthConfig) (*BearerToken, error) {
+	data := url.Values{}
+	if c.Scope != "" {
+		data.Set("scope", c.Scope)
+	}
+	if c.Audience != "" {
+		data.Set("audience", c.Audience)
+	}
+	var auth DeviceAuthorization
+	if err := postForm(ctx, cl, c, c.DeviceAuthorizationURL, data, &auth); err != nil {
+		return nil, err
+	}
+	prompt := c.DeviceCodePrompt
+	if prompt == nil {
+		prompt = printDeviceCode
+	}
+	if err := prompt(auth); err != nil {
+		return nil, err
+	}
+
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultDevicePollInterval
+	}
+	timeout := userAuthTimeout(c)
+	if expiresIn := time.Duration(auth.ExpiresIn) * time.Second; expiresIn > 0 && expiresIn < timeout {
+		timeout = expiresIn
+	}
+	deadline := time.Now().Add(timeout)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		if time.Now().Add(interval).After(deadline) {
+			return nil, ErrUserAuthTimeout
+		}
+		timer.Reset(interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		t, err := requestToken(ctx, cl, c, url.Values{
+			"grant_type":  {GrantTypeDeviceCode},
+			"device_code": {auth.DeviceCode},
+		})
+		switch oauthErrorCode(err) {
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval += devicePollSlowDown
+			continue
+		case "expired_token":
+			return nil, ErrUserAuthTimeout
+		}
+		return t, err
+	}
+}
+
+// userAuthTimeout returns time limit of the user authorization
+func userAuthTimeout(c OAuthConfig) time.Duration {
+	if c.UserAuthTimeout > 0 {
+		return c.UserAuthTimeout
+	}
+	return defaultUserAuthTimeout
+}
+
+// printAuthURL asks the user to open authorization URL
+func printAuthURL(authURL string) error {
+	_, err := fmt.Fprintf(os.Stderr, "Open the following URL in the browser to authorize:\n%s\n", authURL)
+	return err
+}
+
+// printDeviceCode asks the user to enter the user code on the verification page
+func printDeviceCode(auth DeviceAuthorization) error {
+	if auth.VerificationURIComplete != "" {
+		_, err := fmt.Fprintf(os.Stderr, "Open the following URL in the browser to authorize:\n%s\n", auth.VerificationURIComplete)
+		return err
+	}
+	_, err := fmt.Fprintf(os.Stderr, "Open %s in the browser and enter the code %s\n", auth.VerificationURI, auth.UserCode)
+	return err
+}
+
+// randomString returns URL safe string of n random bytes
+func randomString(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
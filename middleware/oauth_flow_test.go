@@ -0,0 +1,235 @@
+package middleware_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shuvava/go-enrichable-client/client"
+	"github.com/shuvava/go-enrichable-client/middleware"
+)
+
+// deviceAuthorization is device authorization endpoint of the auth server
+func deviceAuthorization(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, `{"device_code":"dc","user_code":"ABCD-EFGH","verification_uri":"https://example.com/device","expires_in":60,"interval":1}`)
+}
+
+// browser follows the authorization URL redirecting back with the authorization response
+func browser(response func(query url.Values) url.Values) func(authURL string) error {
+	return func(authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		query := u.Query()
+		redirect, err := url.Parse(query.Get("redirect_uri"))
+		if err != nil {
+			return err
+		}
+		redirect.RawQuery = response(query).Encode()
+		resp, err := http.Get(redirect.String())
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+}
+
+func TestOAuthAuthorizationCode(t *testing.T) {
+	t.Run("Should request token with PKCE authorization code", func(t *testing.T) {
+		var (
+			challenge string
+			wantToken = "123"
+		)
+		server := newAuthServer(t, func(form url.Values) (int, string) {
+			verifier := sha256.Sum256([]byte(form.Get("code_verifier")))
+			if form.Get("grant_type") != middleware.GrantTypeAuthorizationCode || form.Get("code") != "abc" ||
+				base64.RawURLEncoding.EncodeToString(verifier[:]) != challenge ||
+				!strings.HasPrefix(form.Get("redirect_uri"), "http://127.0.0.1:") {
+				return http.StatusBadRequest, `{"error":"invalid_grant"}`
+			}
+			return http.StatusOK, `{"token_type":"Bearer","expires_in":3599,"access_token":"123","refresh_token":"r1"}`
+		})
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			AuthServerURL:    server.URL + "/token",
+			AuthorizationURL: server.URL + "/authorize",
+			GrantType:        middleware.GrantTypeAuthorizationCode,
+			ClientID:         "cli",
+			Scope:            "openid offline_access",
+			OpenBrowser: browser(func(query url.Values) url.Values {
+				if query.Get("response_type") != "code" || query.Get("client_id") != "cli" ||
+					query.Get("code_challenge_method") != "S256" || query.Get("scope") != "openid offline_access" {
+					return url.Values{"error": {"invalid_request"}, "state": {query.Get("state")}}
+				}
+				challenge = query.Get("code_challenge")
+				return url.Values{"code": {"abc"}, "state": {query.Get("state")}}
+			}),
+		}, server.Client())
+
+		token, err := svc.GetToken()
+		if err != nil || token != wantToken {
+			t.Errorf("token got '%s' %v, want '%s'", token, err, wantToken)
+		}
+	})
+	t.Run("Should return error when user denies authorization", func(t *testing.T) {
+		server := newAuthServer(t, func(url.Values) (int, string) {
+			return http.StatusOK, `{"token_type":"Bearer","expires_in":3599,"access_token":"123"}`
+		})
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			AuthServerURL:    server.URL + "/token",
+			AuthorizationURL: server.URL + "/authorize",
+			GrantType:        middleware.GrantTypeAuthorizationCode,
+			ClientID:         "cli",
+			OpenBrowser: browser(func(query url.Values) url.Values {
+				return url.Values{"error": {"access_denied"}, "state": {query.Get("state")}}
+			}),
+		}, server.Client())
+
+		if _, err := svc.GetToken(); err == nil || !strings.Contains(err.Error(), "access_denied") {
+			t.Errorf("expected access_denied error but got %v", err)
+		}
+	})
+	t.Run("Should ignore authorization response with invalid state", func(t *testing.T) {
+		server := newAuthServer(t, func(url.Values) (int, string) {
+			return http.StatusOK, `{"token_type":"Bearer","expires_in":3599,"access_token":"123"}`
+		})
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			AuthServerURL:    server.URL + "/token",
+			AuthorizationURL: server.URL + "/authorize",
+			GrantType:        middleware.GrantTypeAuthorizationCode,
+			ClientID:         "cli",
+			UserAuthTimeout:  100 * time.Millisecond,
+			OpenBrowser: browser(func(url.Values) url.Values {
+				return url.Values{"code": {"abc"}, "state": {"forged"}}
+			}),
+		}, server.Client())
+
+		if _, err := svc.GetToken(); !errors.Is(err, middleware.ErrUserAuthTimeout) {
+			t.Errorf("expected ErrUserAuthTimeout but got %v", err)
+		}
+	})
+	t.Run("Should reject non-loopback redirect URL", func(t *testing.T) {
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			AuthServerURL:    "https://www.example.com/token",
+			AuthorizationURL: "https://www.example.com/authorize",
+			GrantType:        middleware.GrantTypeAuthorizationCode,
+			RedirectURL:      "http://example.com:8080/callback",
+		}, nil)
+
+		if _, err := svc.GetToken(); err == nil {
+			t.Errorf("error should be returned")
+		}
+	})
+}
+
+func TestOAuthDeviceCode(t *testing.T) {
+	t.Run("Should poll token until user authorizes device", func(t *testing.T) {
+		var (
+			mu        sync.Mutex
+			polls     int
+			prompted  middleware.DeviceAuthorization
+			wantToken = "123"
+		)
+		server := newAuthServer(t, func(form url.Values) (int, string) {
+			mu.Lock()
+			defer mu.Unlock()
+			if form.Get("grant_type") != middleware.GrantTypeDeviceCode || form.Get("device_code") != "dc" {
+				return http.StatusBadRequest, `{"error":"invalid_grant"}`
+			}
+			polls++
+			if polls == 1 {
+				return http.StatusBadRequest, `{"error":"authorization_pending"}`
+			}
+			return http.StatusOK, `{"token_type":"Bearer","expires_in":3599,"access_token":"123","refresh_token":"r1"}`
+		})
+		server.handle("/device", deviceAuthorization)
+		var authorization string
+		api := client.NewMockTransport(true)
+		api.RegisterResponder(http.MethodGet, "https://www.example.com", func(request *http.Request) (*http.Response, error) {
+			authorization = request.Header.Get("Authorization")
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: make(http.Header)}, nil
+		})
+		richClient := client.NewClient(api)
+		richClient.Use(middleware.OAuthWithClient(middleware.OAuthConfig{
+			AuthServerURL:          server.URL + "/token",
+			DeviceAuthorizationURL: server.URL + "/device",
+			GrantType:              middleware.GrantTypeDeviceCode,
+			ClientID:               "cli",
+			DeviceCodePrompt: func(auth middleware.DeviceAuthorization) error {
+				prompted = auth
+				return nil
+			},
+		}, server.Client()))
+
+		response, err := richClient.Client.Get("https://www.example.com")
+		assertResponse(t, response, err, http.StatusOK, "")
+		if authorization != "Bearer "+wantToken {
+			t.Errorf("authorization got '%s', want '%s'", authorization, "Bearer "+wantToken)
+		}
+		if prompted.UserCode != "ABCD-EFGH" || prompted.VerificationURI != "https://example.com/device" {
+			t.Errorf("prompt got %+v", prompted)
+		}
+		if polls != 2 {
+			t.Errorf("polls got %d, expected %d", polls, 2)
+		}
+	})
+	t.Run("Should return error when user denies authorization", func(t *testing.T) {
+		server := newAuthServer(t, func(url.Values) (int, string) {
+			return http.StatusBadRequest, `{"error":"access_denied"}`
+		})
+		server.handle("/device", deviceAuthorization)
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			AuthServerURL:          server.URL + "/token",
+			DeviceAuthorizationURL: server.URL + "/device",
+			GrantType:              middleware.GrantTypeDeviceCode,
+			ClientID:               "cli",
+			DeviceCodePrompt:       func(middleware.DeviceAuthorization) error { return nil },
+		}, server.Client())
+
+		if _, err := svc.GetToken(); !client.IsClientError(err) {
+			t.Errorf("expected client error but got %v", err)
+		}
+	})
+	t.Run("Should stop polling when caller is canceled", func(t *testing.T) {
+		var (
+			mu    sync.Mutex
+			polls int
+		)
+		server := newAuthServer(t, func(url.Values) (int, string) {
+			mu.Lock()
+			polls++
+			mu.Unlock()
+			return http.StatusBadRequest, `{"error":"authorization_pending"}`
+		})
+		server.handle("/device", deviceAuthorization)
+		richClient := client.NewClient(client.NewMockTransport(true))
+		richClient.Use(middleware.OAuthWithClient(middleware.OAuthConfig{
+			AuthServerURL:          server.URL + "/token",
+			DeviceAuthorizationURL: server.URL + "/device",
+			GrantType:              middleware.GrantTypeDeviceCode,
+			ClientID:               "cli",
+			DeviceCodePrompt:       func(middleware.DeviceAuthorization) error { return nil },
+		}, server.Client()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://www.example.com", nil)
+		if _, err := richClient.Client.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded error but got %v", err)
+		}
+		// the first poll is due in 1s interval of the device authorization
+		time.Sleep(1200 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if polls != 0 {
+			t.Errorf("polls got %d, expected %d", polls, 0)
+		}
+	})
+}
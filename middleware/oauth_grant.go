@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// These constants are OAuth 2.0 grant types supported by OAuth middleware.
+const (
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypePassword          = "password"
+	GrantTypeJWTBearer         = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	GrantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// These constants are client authentication methods at the token endpoint.
+const (
+	AuthMethodClientSecretPost  = "client_secret_post"
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	AuthMethodPrivateKeyJWT     = "private_key_jwt"
+)
+
+// These constants are token type identifiers of token exchange (RFC 8693 section 3).
+const (
+	TokenTypeAccessToken  = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeRefreshToken = "urn:ietf:params:oauth:token-type:refresh_token"
+	TokenTypeIDToken      = "urn:ietf:params:oauth:token-type:id_token"
+	TokenTypeJWT          = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+const (
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	assertionLifetime   = 5 * time.Minute
+)
+
+// TokenExchangeConfig is configuration of token exchange grant (RFC 8693).
+//
+// SubjectToken is the token representing the identity of the party on behalf of whom the request is made.
+// If SubjectTokenType is empty, TokenTypeAccessToken is used.
+//
+// ActorToken is optional token representing the identity of the acting party.
+// If ActorTokenType is empty, TokenTypeAccessToken is used.
+//
+// RequestedTokenType is optional type of the requested token.
+//
+// Resource is optional URI of the target service where the token is intended to be used.
+type TokenExchangeConfig struct {
+	SubjectToken       string
+	SubjectTokenType   string
+	ActorToken         string
+	ActorTokenType     string
+	RequestedTokenType string
+	Resource           string
+}
+
+// grantForm returns form of the token request.
+// If refreshToken is not empty, refresh_token grant is used instead of the configured one.
+func grantForm(c OAuthConfig, refreshToken string) (url.Values, error) {
+	data := url.Values{}
+	grantType := c.GrantType
+	if grantType == "" {
+		grantType = GrantTypeClientCredentials
+	}
+	if refreshToken != "" {
+		grantType = GrantTypeRefreshToken
+	}
+	data.Set("grant_type", grantType)
+	switch grantType {
+	case GrantTypeClientCredentials:
+	case GrantTypeRefreshToken:
+		if refreshToken == "" {
+			refreshToken = c.RefreshToken
+		}
+		data.Set("refresh_token", refreshToken)
+	case GrantTypePassword:
+		data.Set("username", c.Username)
+		data.Set("password", c.Password)
+	case GrantTypeJWTBearer:
+		assertion, err := jwtBearerAssertion(c)
+		if err != nil {
+			return nil, err
+		}
+		data.Set("assertion", assertion)
+	case GrantTypeTokenExchange:
+		e := c.TokenExchange
+		data.Set("subject_token", e.SubjectToken)
+		data.Set("subject_token_type", valueOrDefault(e.SubjectTokenType, TokenTypeAccessToken))
+		if e.ActorToken != "" {
+			data.Set("actor_token", e.ActorToken)
+			data.Set("actor_token_type", valueOrDefault(e.ActorTokenType, TokenTypeAccessToken))
+		}
+		if e.RequestedTokenType != "" {
+			data.Set("requested_token_type", e.RequestedTokenType)
+		}
+		if e.Resource != "" {
+			data.Set("resource", e.Resource)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported oauth grant type %q", grantType)
+	}
+	if c.Scope != "" {
+		data.Set("scope", c.Scope)
+	}
+	if c.Audience != "" {
+		data.Set("audience", c.Audience)
+	}
+	return data, nil
+}
+
+// jwtBearerAssertion returns assertion of jwt-bearer grant (RFC 7523 section 2.1)
+func jwtBearerAssertion(c OAuthConfig) (string, error) {
+	if c.Assertion != nil {
+		return c.Assertion()
+	}
+	if c.PrivateKey == nil {
+		return "", fmt.Errorf("oauth %s grant requires Assertion or PrivateKey", GrantTypeJWTBearer)
+	}
+	return signAssertion(c, valueOrDefault(c.Subject, c.ClientID))
+}
+
+// signAssertion returns JWT signed by c.PrivateKey issued by the client for the token endpoint (RFC 7523 section 3)
+func signAssertion(c OAuthConfig, subject string) (string, error) {
+	now := time.Now()
+	header := map[string]interface{}{"typ": "JWT"}
+	if c.KeyID != "" {
+		header["kid"] = c.KeyID
+	}
+	return signJWT(c.PrivateKey, header, map[string]interface{}{
+		"iss": c.ClientID,
+		"sub": subject,
+		"aud": c.AuthServerURL,
+		"iat": now.Unix(),
+		"exp": now.Add(assertionLifetime).Unix(),
+		"jti": newJTI(),
+	})
+}
+
+// authenticateClient adds client credentials to the token request (RFC 6749 section 2.3, RFC 7523 section 2.2)
+func authenticateClient(c OAuthConfig, data url.Values, header http.Header) error {
+	switch c.AuthMethod {
+	case "", AuthMethodClientSecretPost:
+		data.Set("client_id", c.ClientID)
+		if c.ClientSecret != "" {
+			data.Set("client_secret", c.ClientSecret)
+		}
+	case AuthMethodClientSecretBasic:
+		credentials := url.QueryEscape(c.ClientID) + ":" + url.QueryEscape(c.ClientSecret)
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	case AuthMethodPrivateKeyJWT:
+		if c.PrivateKey == nil {
+			return fmt.Errorf("oauth %s client authentication requires PrivateKey", AuthMethodPrivateKeyJWT)
+		}
+		assertion, err := signAssertion(c, c.ClientID)
+		if err != nil {
+			return err
+		}
+		data.Set("client_id", c.ClientID)
+		data.Set("client_assertion_type", clientAssertionType)
+		data.Set("client_assertion", assertion)
+	default:
+		return fmt.Errorf("unsupported oauth client authentication method %q", c.AuthMethod)
+	}
+	return nil
+}
+
+// valueOrDefault returns value if it is not empty and def otherwise
+func valueOrDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
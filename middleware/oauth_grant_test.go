@@ -0,0 +1,362 @@
+package middleware_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shuvava/go-enrichable-client/middleware"
+)
+
+// authServer is a local OAuth 2.0 authorization server recording token requests.
+// The token endpoint is served at / and /token, other endpoints are added with handle.
+type authServer struct {
+	*httptest.Server
+	mux      *http.ServeMux
+	mu       sync.Mutex
+	requests []*http.Request
+	forms    []url.Values
+	calls    map[string][]*http.Request // requests of endpoints added with handle by path
+	errs     []error                    // failures of handlers reported by the test
+	response func(form url.Values) (int, string)
+}
+
+func newAuthServer(t *testing.T, response func(form url.Values) (int, string)) *authServer {
+	s := &authServer{mux: http.NewServeMux(), calls: map[string][]*http.Request{}, response: response}
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" && r.URL.Path != "/token" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		s.forms = append(s.forms, r.PostForm)
+		s.mu.Unlock()
+		code, body := http.StatusOK, `{"token_type":"Bearer","expires_in":3599,"access_token":"123"}`
+		if s.response != nil {
+			code, body = s.response(r.PostForm)
+		}
+		writeJSON(w, code, body)
+	})
+	s.Server = httptest.NewServer(s.mux)
+	t.Cleanup(func() {
+		s.Close()
+		for _, err := range s.errs {
+			t.Errorf("auth server: %v", err)
+		}
+	})
+	return s
+}
+
+// handle adds endpoint of the pattern recording its requests
+func (s *authServer) handle(pattern string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.calls[r.URL.Path] = append(s.calls[r.URL.Path], r)
+		s.mu.Unlock()
+		handler(w, r)
+	})
+}
+
+// requestsTo returns requests of the endpoint added with handle
+func (s *authServer) requestsTo(path string) []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*http.Request(nil), s.calls[path]...)
+}
+
+// fail records failure of a handler, it is reported in the test goroutine when the test ends
+func (s *authServer) fail(err error) {
+	s.mu.Lock()
+	s.errs = append(s.errs, err)
+	s.mu.Unlock()
+}
+
+// form returns form of i-th token request
+func (s *authServer) form(i int) url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.forms[i]
+}
+
+func writeJSON(w http.ResponseWriter, code int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte(body))
+}
+
+func assertForm(t *testing.T, form url.Values, want map[string]string) {
+	t.Helper()
+	for k, v := range want {
+		if got := form.Get(k); got != v {
+			t.Errorf("form %s got %q, want %q", k, got, v)
+		}
+	}
+}
+
+// verifyJWT checks signature of the JWT and returns its claims
+func verifyJWT(t *testing.T, token string, key crypto.PublicKey) map[string]interface{} {
+	t.Helper()
+	claims, err := jwtClaims(token, key)
+	if err != nil {
+		t.Fatalf("did not expect an error but got one %v", err)
+	}
+	return claims
+}
+
+// jwtClaims checks signature of the JWT and returns its claims.
+// Unlike verifyJWT it can be used by server handlers.
+func jwtClaims(token string, key crypto.PublicKey) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid jwt %q", token)
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	var ok bool
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		ok = len(sig) == 64 && ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(k, []byte(parts[0]+"."+parts[1]), sig)
+	}
+	if !ok {
+		return nil, fmt.Errorf("invalid jwt signature")
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func TestOAuthGrantTypes(t *testing.T) {
+	t.Run("Should request token with grant", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			config   middleware.OAuthConfig
+			wantForm map[string]string
+		}{
+			{
+				name:   "client_credentials",
+				config: middleware.OAuthConfig{ClientID: "1", ClientSecret: "2", Scope: "read", Audience: "api"},
+				wantForm: map[string]string{
+					"grant_type": "client_credentials", "client_id": "1", "client_secret": "2", "scope": "read", "audience": "api",
+				},
+			},
+			{
+				name:   "refresh_token",
+				config: middleware.OAuthConfig{GrantType: middleware.GrantTypeRefreshToken, ClientID: "1", RefreshToken: "r1"},
+				wantForm: map[string]string{
+					"grant_type": "refresh_token", "client_id": "1", "refresh_token": "r1",
+				},
+			},
+			{
+				name:   "password",
+				config: middleware.OAuthConfig{GrantType: middleware.GrantTypePassword, ClientID: "1", Username: "user", Password: "pass"},
+				wantForm: map[string]string{
+					"grant_type": "password", "username": "user", "password": "pass",
+				},
+			},
+			{
+				name: "jwt-bearer",
+				config: middleware.OAuthConfig{
+					GrantType: middleware.GrantTypeJWTBearer,
+					ClientID:  "1",
+					Assertion: func() (string, error) { return "a.b.c", nil },
+				},
+				wantForm: map[string]string{
+					"grant_type": middleware.GrantTypeJWTBearer, "assertion": "a.b.c",
+				},
+			},
+			{
+				name: "token-exchange",
+				config: middleware.OAuthConfig{
+					GrantType: middleware.GrantTypeTokenExchange,
+					ClientID:  "1",
+					Audience:  "backend",
+					TokenExchange: middleware.TokenExchangeConfig{
+						SubjectToken:       "subject",
+						ActorToken:         "actor",
+						ActorTokenType:     middleware.TokenTypeJWT,
+						RequestedTokenType: middleware.TokenTypeAccessToken,
+						Resource:           "https://backend.example.com",
+					},
+				},
+				wantForm: map[string]string{
+					"grant_type":           middleware.GrantTypeTokenExchange,
+					"subject_token":        "subject",
+					"subject_token_type":   middleware.TokenTypeAccessToken,
+					"actor_token":          "actor",
+					"actor_token_type":     middleware.TokenTypeJWT,
+					"requested_token_type": middleware.TokenTypeAccessToken,
+					"resource":             "https://backend.example.com",
+					"audience":             "backend",
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				server := newAuthServer(t, nil)
+				tt.config.AuthServerURL = server.URL
+				svc := middleware.NewOAuthService(tt.config, server.Client())
+
+				token, err := svc.GetToken()
+				if err != nil || token != "123" {
+					t.Fatalf("token got '%s' %v, want '%s'", token, err, "123")
+				}
+				assertForm(t, server.form(0), tt.wantForm)
+			})
+		}
+	})
+	t.Run("Should sign jwt-bearer assertion with private key", func(t *testing.T) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		server := newAuthServer(t, nil)
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			AuthServerURL: server.URL,
+			GrantType:     middleware.GrantTypeJWTBearer,
+			ClientID:      "1",
+			Subject:       "user@example.com",
+			PrivateKey:    key,
+		}, server.Client())
+
+		if _, err := svc.GetToken(); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		claims := verifyJWT(t, server.form(0).Get("assertion"), key.Public())
+		if claims["iss"] != "1" || claims["sub"] != "user@example.com" || claims["aud"] != server.URL {
+			t.Errorf("claims got %v", claims)
+		}
+	})
+	t.Run("Should authenticate client with HTTP Basic", func(t *testing.T) {
+		server := newAuthServer(t, nil)
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			AuthServerURL: server.URL,
+			ClientID:      "client:1",
+			ClientSecret:  "secret/2",
+			AuthMethod:    middleware.AuthMethodClientSecretBasic,
+		}, server.Client())
+
+		if _, err := svc.GetToken(); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		id, secret, ok := server.requests[0].BasicAuth()
+		if !ok || id != url.QueryEscape("client:1") || secret != url.QueryEscape("secret/2") {
+			t.Errorf("basic auth got %q %q %v", id, secret, ok)
+		}
+		if form := server.form(0); form.Has("client_secret") {
+			t.Errorf("client_secret should not be sent in form")
+		}
+	})
+	t.Run("Should authenticate client with private_key_jwt", func(t *testing.T) {
+		rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+		ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+		for _, key := range []crypto.Signer{rsaKey, ecKey, edKey} {
+			t.Run(fmt.Sprintf("%T", key), func(t *testing.T) {
+				server := newAuthServer(t, nil)
+				svc := middleware.NewOAuthService(middleware.OAuthConfig{
+					AuthServerURL: server.URL,
+					ClientID:      "1",
+					AuthMethod:    middleware.AuthMethodPrivateKeyJWT,
+					PrivateKey:    key,
+					KeyID:         "key-1",
+				}, server.Client())
+
+				if _, err := svc.GetToken(); err != nil {
+					t.Fatalf("did not expect an error but got one %v", err)
+				}
+				form := server.form(0)
+				assertForm(t, form, map[string]string{
+					"client_id":             "1",
+					"client_assertion_type": "urn:ietf:params:oauth:client-assertion-type:jwt-bearer",
+				})
+				claims := verifyJWT(t, form.Get("client_assertion"), key.Public())
+				if claims["iss"] != "1" || claims["sub"] != "1" || claims["aud"] != server.URL || claims["jti"] == "" {
+					t.Errorf("claims got %v", claims)
+				}
+			})
+		}
+	})
+	t.Run("Should refresh token with issued refresh token", func(t *testing.T) {
+		server := newAuthServer(t, func(form url.Values) (int, string) {
+			if form.Get("grant_type") == middleware.GrantTypeRefreshToken {
+				return http.StatusOK, `{"token_type":"Bearer","expires_in":3599,"access_token":"456"}`
+			}
+			return http.StatusOK, `{"token_type":"Bearer","access_token":"123","refresh_token":"r1"}`
+		})
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			AuthServerURL:        server.URL,
+			ClientID:             "1",
+			ClientSecret:         "2",
+			DefaultTokenLifetime: time.Millisecond,
+		}, server.Client())
+
+		_, _ = svc.GetToken()
+		time.Sleep(5 * time.Millisecond)
+		token, err := svc.GetToken()
+		if err != nil || token != "456" {
+			t.Errorf("token got '%s' %v, want '%s'", token, err, "456")
+		}
+		assertForm(t, server.form(1), map[string]string{"grant_type": "refresh_token", "refresh_token": "r1"})
+	})
+	t.Run("Should fall back to grant when refresh token is rejected", func(t *testing.T) {
+		server := newAuthServer(t, func(form url.Values) (int, string) {
+			if form.Get("grant_type") == middleware.GrantTypeRefreshToken {
+				return http.StatusBadRequest, `{"error":"invalid_grant"}`
+			}
+			return http.StatusOK, `{"token_type":"Bearer","access_token":"123","refresh_token":"r1"}`
+		})
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			AuthServerURL:        server.URL,
+			ClientID:             "1",
+			ClientSecret:         "2",
+			DefaultTokenLifetime: time.Millisecond,
+		}, server.Client())
+
+		_, _ = svc.GetToken()
+		time.Sleep(5 * time.Millisecond)
+		token, err := svc.GetToken()
+		if err != nil || token != "123" {
+			t.Errorf("token got '%s' %v, want '%s'", token, err, "123")
+		}
+		assertForm(t, server.form(2), map[string]string{"grant_type": "client_credentials"})
+	})
+	t.Run("Should return error on unsupported grant type", func(t *testing.T) {
+		server := newAuthServer(t, nil)
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			AuthServerURL: server.URL,
+			GrantType:     "implicit",
+		}, server.Client())
+
+		if _, err := svc.GetToken(); err == nil {
+			t.Errorf("error should be returned")
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TokenKey identifies the token in TokenStore
+type TokenKey struct {
+	ClientID string
+	Scope    string
+	Audience string
+}
+
+// String returns the key as a string
+func (k TokenKey) String() string {
+	return strings.Join([]string{k.ClientID, k.Scope, k.Audience}, "|")
+}
+
+// TokenStore is a storage of tokens used by OAuthService.
+// Implementations must be safe for concurrent use.
+type TokenStore interface {
+	// Get returns the token stored by the key or nil if it does not exist
+	Get(key TokenKey) (*BearerToken, error)
+	// Put stores the token by the key
+	Put(key TokenKey, token *BearerToken) error
+	// Invalidate removes the token stored by the key
+	Invalidate(key TokenKey) error
+}
+
+// MemoryTokenStore is an in-memory TokenStore.
+type MemoryTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[TokenKey]*BearerToken
+}
+
+// NewMemoryTokenStore creates MemoryTokenStore
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: map[TokenKey]*BearerToken{}}
+}
+
+// Get implements TokenStore interface.
+func (s *MemoryTokenStore) Get(key TokenKey) (*BearerToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tokens[key], nil
+}
+
+// Put implements TokenStore interface.
+func (s *MemoryTokenStore) Put(key TokenKey, token *BearerToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[key] = token
+	return nil
+}
+
+// Invalidate implements TokenStore interface.
+func (s *MemoryTokenStore) Invalidate(key TokenKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, key)
+	return nil
+}
+
+// FileTokenStore is a TokenStore keeping tokens in a JSON file, optionally encrypted.
+// The file is readable only by the owner and replaced atomically,
+// so it can be shared by restarts and processes of the application.
+type FileTokenStore struct {
+	path string
+	aead cipher.AEAD
+
+	mu sync.Mutex
+}
+
+// NewFileTokenStore creates FileTokenStore keeping tokens in the plain file.
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{path: path}
+}
+
+// NewEncryptedFileTokenStore creates FileTokenStore keeping tokens in the file encrypted by AES-GCM.
+// The key must be 16, 24 or 32 bytes long.
+func NewEncryptedFileTokenStore(path string, key []byte) (*FileTokenStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &FileTokenStore{path: path, aead: aead}, nil
+}
+
+// Get implements TokenStore interface.
+func (s *FileTokenStore) Get(key TokenKey) (*BearerToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	return tokens[key.String()], nil
+}
+
+// Put implements TokenStore interface.
+func (s *FileTokenStore) Put(key TokenKey, token *BearerToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, err := s.read()
+	if err != nil {
+		return err
+	}
+	tokens[key.String()] = token
+	return s.write(tokens)
+}
+
+// Invalidate implements TokenStore interface.
+func (s *FileTokenStore) Invalidate(key TokenKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := tokens[key.String()]; !ok {
+		return nil
+	}
+	delete(tokens, key.String())
+	return s.write(tokens)
+}
+
+// read returns tokens of the file
+func (s *FileTokenStore) read() (map[string]*BearerToken, error) {
+	tokens := map[string]*BearerToken{}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.aead != nil {
+		size := s.aead.NonceSize()
+		if len(data) < size {
+			return nil, fmt.Errorf("token store %s is corrupted", s.path)
+		}
+		if data, err = s.aead.Open(nil, data[:size], data[size:], nil); err != nil {
+			return nil, err
+		}
+	}
+	if err = json.Unmarshal(data, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// write replaces the file with tokens
+func (s *FileTokenStore) write(tokens map[string]*BearerToken) error {
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	if s.aead != nil {
+		nonce := make([]byte, s.aead.NonceSize())
+		if _, err = rand.Read(nonce); err != nil {
+			return err
+		}
+		data = s.aead.Seal(nonce, nonce, data, nil)
+	}
+	dir := filepath.Dir(s.path)
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
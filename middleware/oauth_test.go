@@ -1,9 +1,17 @@
 package middleware_test
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/shuvava/go-enrichable-client/client"
 	"github.com/shuvava/go-enrichable-client/middleware"
@@ -113,7 +121,7 @@ func TestOAuthMiddleware(t *testing.T) {
 		m := createMockMultiResponse(http.MethodPost, url, []responseMock{
 			{
 				StatusCode: http.StatusOK,
-				Body:       `{"token_type":"Bearer","expires_in":0,"access_token": "123"}`,
+				Body:       `{"token_type":"Bearer","access_token": "123"}`,
 			},
 			{
 				StatusCode: http.StatusOK,
@@ -123,11 +131,13 @@ func TestOAuthMiddleware(t *testing.T) {
 		richClient := client.NewClient(m.mock)
 		c := richClient.Client
 		svc := middleware.NewOAuthService(middleware.OAuthConfig{
-			AuthServerURL: url,
-			ClientID:      "1",
-			ClientSecret:  "2",
+			AuthServerURL:        url,
+			ClientID:             "1",
+			ClientSecret:         "2",
+			DefaultTokenLifetime: time.Millisecond,
 		}, c)
 		token, _ := svc.GetToken()
+		time.Sleep(5 * time.Millisecond)
 		start := make(chan struct{})
 		wg.Add(10)
 		for i := 0; i < 10; i++ {
@@ -147,4 +157,342 @@ func TestOAuthMiddleware(t *testing.T) {
 			t.Errorf("retry got %d, expected %d", m.calls, wantCalls)
 		}
 	})
+	t.Run("Should refresh token ahead of expiration", func(t *testing.T) {
+		var (
+			url = "https://YOUR_DOMAIN/oauth/token"
+		)
+
+		m := createMockMultiResponse(http.MethodPost, url, []responseMock{
+			{
+				StatusCode: http.StatusOK,
+				Body:       `{"token_type":"Bearer","expires_in":1,"access_token": "123"}`,
+			},
+			{
+				StatusCode: http.StatusOK,
+				Body:       `{"token_type":"Bearer","expires_in":3599,"access_token": "456"}`,
+			},
+		})
+		richClient := client.NewClient(m.mock)
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			AuthServerURL: url,
+			ClientID:      "1",
+			ClientSecret:  "2",
+		}, richClient.Client)
+
+		token, _ := svc.GetToken()
+		if token != "123" {
+			t.Errorf("token got '%s', want '%s'", token, "123")
+		}
+		// token is still valid but is due to refresh after half of its lifetime
+		time.Sleep(600 * time.Millisecond)
+		token, _ = svc.GetToken()
+		if token != "456" {
+			t.Errorf("token got '%s', want '%s'", token, "456")
+		}
+		if m.calls != 2 {
+			t.Errorf("retry got %d, expected %d", m.calls, 2)
+		}
+	})
+	t.Run("Should refresh token in background", func(t *testing.T) {
+		var (
+			url = "https://YOUR_DOMAIN/oauth/token"
+		)
+
+		m := createMockMultiResponse(http.MethodPost, url, []responseMock{
+			{
+				StatusCode: http.StatusOK,
+				Body:       `{"token_type":"Bearer","expires_in":1,"access_token": "123"}`,
+			},
+			{
+				StatusCode: http.StatusOK,
+				Body:       `{"token_type":"Bearer","expires_in":3599,"access_token": "456"}`,
+			},
+		})
+		richClient := client.NewClient(m.mock)
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			AuthServerURL:     url,
+			ClientID:          "1",
+			ClientSecret:      "2",
+			BackgroundRefresh: true,
+		}, richClient.Client)
+
+		_, _ = svc.GetToken()
+		time.Sleep(600 * time.Millisecond)
+		token, _ := svc.GetToken()
+		if token != "123" {
+			t.Errorf("token got '%s', want current token '%s'", token, "123")
+		}
+		deadline := time.Now().Add(time.Second)
+		for token != "456" && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			token, _ = svc.GetToken()
+		}
+		if token != "456" {
+			t.Errorf("token got '%s', want '%s'", token, "456")
+		}
+		if m.calls != 2 {
+			t.Errorf("retry got %d, expected %d", m.calls, 2)
+		}
+	})
+	t.Run("Should back off after token request failure", func(t *testing.T) {
+		var (
+			url       = "https://YOUR_DOMAIN/oauth/token"
+			wantToken = "123"
+		)
+
+		m := createMockMultiResponse(http.MethodPost, url, []responseMock{
+			{
+				StatusCode: http.StatusInternalServerError,
+				Body:       `error`,
+			},
+			{
+				StatusCode: http.StatusOK,
+				Body:       `{"token_type":"Bearer","expires_in":3599,"access_token": "123"}`,
+			},
+		})
+		richClient := client.NewClient(m.mock)
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			AuthServerURL: url,
+			ClientID:      "1",
+			ClientSecret:  "2",
+			ErrorBackoff:  50 * time.Millisecond,
+		}, richClient.Client)
+
+		for i := 0; i < 2; i++ {
+			if _, err := svc.GetToken(); err == nil {
+				t.Errorf("error should be returned")
+			}
+		}
+		if m.calls != 1 {
+			t.Errorf("retry got %d, expected %d", m.calls, 1)
+		}
+		time.Sleep(60 * time.Millisecond)
+		token, err := svc.GetToken()
+		if err != nil || token != wantToken {
+			t.Errorf("token got '%s' %v, want '%s'", token, err, wantToken)
+		}
+		if m.calls != 2 {
+			t.Errorf("retry got %d, expected %d", m.calls, 2)
+		}
+	})
+	t.Run("Should replay request with new token when token is rejected", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			policy    middleware.ReauthorizePolicy
+			code      int
+			challenge string
+			wantCode  int
+			wantCalls int
+		}{
+			{name: "401", code: http.StatusUnauthorized, wantCode: http.StatusOK, wantCalls: 2},
+			{name: "invalid_token challenge", code: http.StatusBadRequest, challenge: `Bearer realm="api", error="invalid_token"`, wantCode: http.StatusOK, wantCalls: 2},
+			{name: "custom policy", policy: middleware.ReauthorizeOnStatus(http.StatusForbidden), code: http.StatusForbidden, wantCode: http.StatusOK, wantCalls: 2},
+			{name: "not rejected", code: http.StatusForbidden, wantCode: http.StatusForbidden, wantCalls: 1},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var (
+					tokenURL = "https://YOUR_DOMAIN/oauth/token"
+					url      = "https://www.example.com"
+					wantBody = `{"id":1}`
+					calls    int
+				)
+				m := createMockMultiResponse(http.MethodPost, tokenURL, []responseMock{
+					{StatusCode: http.StatusOK, Body: `{"token_type":"Bearer","expires_in":3599,"access_token": "123"}`},
+					{StatusCode: http.StatusOK, Body: `{"token_type":"Bearer","expires_in":3599,"access_token": "456"}`},
+				})
+				api := client.NewMockTransport(true)
+				api.RegisterResponder(http.MethodPost, url, func(request *http.Request) (*http.Response, error) {
+					calls++
+					body, _ := io.ReadAll(request.Body)
+					code := http.StatusOK
+					header := make(http.Header)
+					if request.Header.Get("Authorization") != "Bearer 456" {
+						code = tt.code
+						if tt.challenge != "" {
+							header.Set("WWW-Authenticate", tt.challenge)
+						}
+					}
+					return &http.Response{
+						StatusCode: code,
+						Body:       io.NopCloser(bytes.NewReader(body)),
+						Header:     header,
+					}, nil
+				})
+				richClient := client.NewClient(api)
+				richClient.Use(middleware.OAuthWithClient(middleware.OAuthConfig{
+					AuthServerURL:     tokenURL,
+					ClientID:          "1",
+					ClientSecret:      "2",
+					ReauthorizePolicy: tt.policy,
+				}, client.NewClient(m.mock).Client))
+
+				response, err := richClient.Client.Post(url, client.ContentTypeJSON, strings.NewReader(wantBody))
+				assertResponse(t, response, err, tt.wantCode, wantBody)
+				if calls != tt.wantCalls {
+					t.Errorf("calls got %d, expected %d", calls, tt.wantCalls)
+				}
+				if m.calls != tt.wantCalls {
+					t.Errorf("token calls got %d, expected %d", m.calls, tt.wantCalls)
+				}
+			})
+		}
+	})
+	t.Run("Should replay request only once", func(t *testing.T) {
+		var (
+			tokenURL = "https://YOUR_DOMAIN/oauth/token"
+			url      = "https://www.example.com"
+		)
+		m := createMockMultiResponse(http.MethodPost, tokenURL, []responseMock{
+			{StatusCode: http.StatusOK, Body: `{"token_type":"Bearer","expires_in":3599,"access_token": "123"}`},
+			{StatusCode: http.StatusOK, Body: `{"token_type":"Bearer","expires_in":3599,"access_token": "456"}`},
+		})
+		api := createGetMock(url, http.StatusUnauthorized, "", 0, 0)
+		richClient := client.NewClient(api.mock)
+		richClient.Use(middleware.OAuthWithClient(middleware.OAuthConfig{
+			AuthServerURL: tokenURL,
+			ClientID:      "1",
+			ClientSecret:  "2",
+		}, client.NewClient(m.mock).Client))
+
+		response, err := richClient.Client.Get(url)
+		assertResponse(t, response, err, http.StatusUnauthorized, "")
+		if api.calls != 2 {
+			t.Errorf("calls got %d, expected %d", api.calls, 2)
+		}
+	})
+}
+
+func TestOAuthTokenStore(t *testing.T) {
+	t.Run("Should share token between services with store", func(t *testing.T) {
+		server := newAuthServer(t, nil)
+		store := middleware.NewFileTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+		config := middleware.OAuthConfig{
+			AuthServerURL: server.URL,
+			ClientID:      "1",
+			ClientSecret:  "2",
+			Scope:         "read",
+			TokenStore:    store,
+		}
+		for i := 0; i < 2; i++ {
+			svc := middleware.NewOAuthService(config, server.Client())
+			token, err := svc.GetToken()
+			if err != nil || token != "123" {
+				t.Errorf("token got '%s' %v, want '%s'", token, err, "123")
+			}
+		}
+		if len(server.forms) != 1 {
+			t.Errorf("token requests got %d, expected %d", len(server.forms), 1)
+		}
+	})
+	t.Run("Should remove rejected token from store", func(t *testing.T) {
+		server := newAuthServer(t, nil)
+		store := middleware.NewMemoryTokenStore()
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{
+			AuthServerURL: server.URL,
+			ClientID:      "1",
+			TokenStore:    store,
+		}, server.Client())
+
+		token, _ := svc.GetToken()
+		svc.InvalidateToken(token)
+		if stored, _ := store.Get(middleware.TokenKey{ClientID: "1"}); stored != nil {
+			t.Errorf("token should be invalidated, got %v", stored)
+		}
+	})
+	t.Run("Should hold tokens of several scopes", func(t *testing.T) {
+		server := newAuthServer(t, func(form url.Values) (int, string) {
+			return http.StatusOK, `{"token_type":"Bearer","expires_in":3599,"access_token":"` + form.Get("scope") + "@" + form.Get("audience") + `"}`
+		})
+		var authorization string
+		api := client.NewMockTransport(true)
+		api.RegisterResponder(http.MethodGet, "https://www.example.com", func(request *http.Request) (*http.Response, error) {
+			authorization = request.Header.Get("Authorization")
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: make(http.Header)}, nil
+		})
+		config := middleware.OAuthConfig{
+			AuthServerURL: server.URL,
+			ClientID:      "1",
+			Scope:         "read",
+			Audience:      "orders",
+		}
+		richClient := client.NewClient(api)
+		richClient.Use(middleware.OAuthWithClient(config, server.Client()))
+
+		response, err := richClient.Client.Get("https://www.example.com")
+		assertResponse(t, response, err, http.StatusOK, "")
+		if authorization != "Bearer read@orders" {
+			t.Errorf("authorization got '%s', want '%s'", authorization, "Bearer read@orders")
+		}
+		req, _ := client.NewRequest(context.Background(), http.MethodGet, "https://www.example.com", nil,
+			middleware.WithOAuthScope("write", "billing"))
+		response, err = richClient.Client.Do(req.Request)
+		assertResponse(t, response, err, http.StatusOK, "")
+		if authorization != "Bearer write@billing" {
+			t.Errorf("authorization got '%s', want '%s'", authorization, "Bearer write@billing")
+		}
+
+		svc := middleware.NewOAuthService(config, server.Client())
+		token, _ := svc.GetScopedToken("admin", "")
+		if token != "admin@" {
+			t.Errorf("token got '%s', want '%s'", token, "admin@")
+		}
+	})
+}
+
+func TestTokenStore(t *testing.T) {
+	var (
+		key   = middleware.TokenKey{ClientID: "1", Scope: "read write", Audience: "api"}
+		token = &middleware.BearerToken{AccessToken: "secret-token", RefreshToken: "r1", ExpirationTokenTime: time.Now().Add(time.Hour).Round(0)}
+	)
+	encrypted, err := middleware.NewEncryptedFileTokenStore(filepath.Join(t.TempDir(), "tokens"), bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("did not expect an error but got one %v", err)
+	}
+	stores := map[string]middleware.TokenStore{
+		"memory":    middleware.NewMemoryTokenStore(),
+		"file":      middleware.NewFileTokenStore(filepath.Join(t.TempDir(), "dir", "tokens.json")),
+		"encrypted": encrypted,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if got, err := store.Get(key); err != nil || got != nil {
+				t.Errorf("got %v %v, expected no token", got, err)
+			}
+			if err := store.Put(key, token); err != nil {
+				t.Fatalf("did not expect an error but got one %v", err)
+			}
+			got, err := store.Get(key)
+			if err != nil || got == nil || got.AccessToken != token.AccessToken || got.RefreshToken != token.RefreshToken ||
+				!got.ExpirationTokenTime.Equal(token.ExpirationTokenTime) {
+				t.Errorf("got %v %v, want %v", got, err, token)
+			}
+			if got, _ := store.Get(middleware.TokenKey{ClientID: "1", Scope: "read"}); got != nil {
+				t.Errorf("got %v for another scope", got)
+			}
+			if err := store.Invalidate(key); err != nil {
+				t.Fatalf("did not expect an error but got one %v", err)
+			}
+			if got, _ := store.Get(key); got != nil {
+				t.Errorf("got %v, expected no token", got)
+			}
+		})
+	}
+	t.Run("Should keep file private and encrypted", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tokens")
+		store, _ := middleware.NewEncryptedFileTokenStore(path, bytes.Repeat([]byte{1}, 32))
+		_ = store.Put(key, token)
+		info, err := os.Stat(path)
+		if err != nil || info.Mode().Perm() != 0o600 {
+			t.Errorf("file mode got %v %v, want %v", info.Mode().Perm(), err, os.FileMode(0o600))
+		}
+		data, _ := os.ReadFile(path)
+		if bytes.Contains(data, []byte(token.AccessToken)) {
+			t.Errorf("token should be encrypted")
+		}
+		other, _ := middleware.NewEncryptedFileTokenStore(path, bytes.Repeat([]byte{2}, 32))
+		if _, err = other.Get(key); err == nil {
+			t.Errorf("error should be returned for wrong key")
+		}
+	})
 }
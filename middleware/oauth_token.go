@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shuvava/go-enrichable-client/client"
+)
+
+// These constants are token types (RFC 6749 section 7.1) supported by OAuth middleware.
+const (
+	TokenTypeBearer = "Bearer"
+	TokenTypeDPoP   = "DPoP"
+	// TokenTypeMAC is MAC access authentication (draft-ietf-oauth-v2-http-mac)
+	TokenTypeMAC = "MAC"
+)
+
+const defaultTokenLifetime = time.Hour
+
+// OAuthError is error response of the authorization server (RFC 6749 section 5.2)
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	URI         string `json:"error_uri"`
+	// HTTPError is the unexpected HTTP status of the response
+	HTTPError *client.HTTPError `json:"-"`
+}
+
+// Error implements error interface.
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("oauth error %s", e.Code)
+	}
+	return fmt.Sprintf("oauth error %s: %s", e.Code, e.Description)
+}
+
+// Unwrap returns HTTPError of the response.
+func (e *OAuthError) Unwrap() error {
+	if e.HTTPError == nil {
+		return nil
+	}
+	return e.HTTPError
+}
+
+// asOAuthError returns OAuthError if err is HTTPError with OAuth error response and err otherwise
+func asOAuthError(err error) error {
+	var httpErr *client.HTTPError
+	if !errors.As(err, &httpErr) {
+		return err
+	}
+	oauthErr := &OAuthError{HTTPError: httpErr}
+	if json.Unmarshal(httpErr.Body, oauthErr) != nil || oauthErr.Code == "" {
+		return err
+	}
+	return oauthErr
+}
+
+// oauthErrorCode returns error code of OAuthError
+func oauthErrorCode(err error) string {
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) {
+		return ""
+	}
+	return oauthErr.Code
+}
+
+// UnmarshalJSON decodes the response accepting expires_in as a number or a numeric string.
+func (r *BearerResponse) UnmarshalJSON(data []byte) error {
+	type response BearerResponse
+	aux := struct {
+		*response
+		ExpiresIn json.RawMessage `json:"expires_in"`
+	}{response: (*response)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	r.ExpiresIn = 0
+	raw := strings.Trim(string(aux.ExpiresIn), `"`)
+	if raw == "" || raw == "null" {
+		return nil
+	}
+	expiresIn, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return fmt.Errorf("invalid oauth expires_in %s", aux.ExpiresIn)
+	}
+	r.ExpiresIn = int(expiresIn)
+	return nil
+}
+
+// token returns the token of the response.
+// If expires_in is absent or zero, the expiration is taken from exp claim of JWT access token,
+// the token without any expiration lasts defaultLifetime.
+func (r *BearerResponse) token(now time.Time, defaultLifetime time.Duration) (*BearerToken, error) {
+	if r.AccessToken == "" {
+		return nil, errors.New("oauth token response has no access_token")
+	}
+	tokenType, err := normalizeTokenType(r.TokenType)
+	if err != nil {
+		return nil, err
+	}
+	if defaultLifetime <= 0 {
+		defaultLifetime = defaultTokenLifetime
+	}
+	expiration := now.Add(defaultLifetime)
+	if r.ExpiresIn > 0 {
+		expiration = now.Add(time.Second * time.Duration(r.ExpiresIn))
+	} else if exp, ok := jwtExpiration(r.AccessToken); ok {
+		expiration = exp
+	}
+	return &BearerToken{
+		AccessToken:         r.AccessToken,
+		TokenType:           tokenType,
+		ExpirationTokenTime: expiration,
+		RefreshToken:        r.RefreshToken,
+		MACKey:              r.MACKey,
+		MACAlgorithm:        r.MACAlgorithm,
+	}, nil
+}
+
+// normalizeTokenType returns the canonical name of the token type.
+// The token type is case-insensitive, the absent type is Bearer.
+func normalizeTokenType(tokenType string) (string, error) {
+	for _, t := range []string{TokenTypeBearer, TokenTypeDPoP, TokenTypeMAC} {
+		if strings.EqualFold(tokenType, t) {
+			return t, nil
+		}
+	}
+	if tokenType == "" {
+		return TokenTypeBearer, nil
+	}
+	return "", fmt.Errorf("unsupported oauth token type %q", tokenType)
+}
+
+// jwtExpiration returns exp claim of the JWT without verifying its signature
+func jwtExpiration(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp json.Number `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil {
+		return time.Time{}, false
+	}
+	exp, err := claims.Exp.Float64()
+	if err != nil || exp <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(exp), 0), true
+}
+
+// authorization returns Authorization header value of the request with the token
+func (t *BearerToken) authorization(request *http.Request) (string, error) {
+	switch t.TokenType {
+	case TokenTypeMAC:
+		return macAuthorization(t, request, time.Now())
+	case TokenTypeDPoP:
+		return TokenTypeDPoP + " " + t.AccessToken, nil
+	}
+	return TokenTypeBearer + " " + t.AccessToken, nil
+}
+
+// macAuthorization returns MAC Authorization header value (draft-ietf-oauth-v2-http-mac-02 section 3.1)
+func macAuthorization(t *BearerToken, request *http.Request, now time.Time) (string, error) {
+	var h func() hash.Hash
+	switch strings.ToLower(t.MACAlgorithm) {
+	case "hmac-sha-1":
+		h = sha1.New
+	case "hmac-sha-256", "":
+		h = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported oauth mac algorithm %q", t.MACAlgorithm)
+	}
+	port := request.URL.Port()
+	if port == "" {
+		port = "80"
+		if request.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	ts := strconv.FormatInt(now.Unix(), 10)
+	nonce := randomString(8)
+	normalized := strings.Join([]string{
+		ts, nonce, request.Method, request.URL.RequestURI(), strings.ToLower(request.URL.Hostname()), port, "",
+	}, "\n") + "\n"
+	mac := hmac.New(h, []byte(t.MACKey))
+	mac.Write([]byte(normalized))
+	return fmt.Sprintf(`MAC id="%s", ts="%s", nonce="%s", mac="%s"`,
+		t.AccessToken, ts, nonce, base64.StdEncoding.EncodeToString(mac.Sum(nil))), nil
+}
@@ -0,0 +1,172 @@
+package middleware_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shuvava/go-enrichable-client/client"
+	"github.com/shuvava/go-enrichable-client/middleware"
+)
+
+// jwtWithExp returns unsigned JWT with exp claim
+func jwtWithExp(exp time.Time) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	return enc([]byte(`{"alg":"none"}`)) + "." + enc([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix()))) + "."
+}
+
+// authorizationOf returns Authorization headers of the request made by the client with the token response
+func authorizationOf(t *testing.T, tokenResponse string) ([]string, error) {
+	t.Helper()
+	server := newAuthServer(t, func(url.Values) (int, string) {
+		return http.StatusOK, tokenResponse
+	})
+	var authorization []string
+	api := client.NewMockTransport(true)
+	api.RegisterResponder(http.MethodGet, "https://www.example.com/items?id=1", func(request *http.Request) (*http.Response, error) {
+		authorization = request.Header.Values("Authorization")
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: make(http.Header)}, nil
+	})
+	richClient := client.NewClient(api)
+	richClient.Use(middleware.OAuthWithClient(middleware.OAuthConfig{AuthServerURL: server.URL, ClientID: "1"}, server.Client()))
+	response, err := richClient.Client.Get("https://www.example.com/items?id=1")
+	if err != nil {
+		return nil, err
+	}
+	_ = response.Body.Close()
+	return authorization, nil
+}
+
+func TestOAuthTokenResponse(t *testing.T) {
+	t.Run("Should handle expires_in forms", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			body      string
+			wantCalls int
+		}{
+			{name: "number", body: `{"access_token":"123","expires_in":3600}`, wantCalls: 1},
+			{name: "string", body: `{"access_token":"123","expires_in":"3600"}`, wantCalls: 1},
+			{name: "absent", body: `{"access_token":"123"}`, wantCalls: 1},
+			{name: "zero", body: `{"access_token":"123","expires_in":0}`, wantCalls: 1},
+			{name: "absent with jwt exp", body: `{"access_token":"` + jwtWithExp(time.Now().Add(time.Hour)) + `"}`, wantCalls: 1},
+			{name: "absent with expired jwt", body: `{"access_token":"` + jwtWithExp(time.Now().Add(-time.Hour)) + `"}`, wantCalls: 2},
+			{name: "zero with jwt exp", body: `{"access_token":"` + jwtWithExp(time.Now().Add(time.Hour)) + `","expires_in":0}`, wantCalls: 1},
+			{name: "zero with expired jwt", body: `{"access_token":"` + jwtWithExp(time.Now().Add(-time.Hour)) + `","expires_in":0}`, wantCalls: 2},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				server := newAuthServer(t, func(url.Values) (int, string) {
+					return http.StatusOK, tt.body
+				})
+				svc := middleware.NewOAuthService(middleware.OAuthConfig{AuthServerURL: server.URL, ClientID: "1"}, server.Client())
+				for i := 0; i < 2; i++ {
+					if _, err := svc.GetToken(); err != nil {
+						t.Fatalf("did not expect an error but got one %v", err)
+					}
+				}
+				if len(server.forms) != tt.wantCalls {
+					t.Errorf("token requests got %d, expected %d", len(server.forms), tt.wantCalls)
+				}
+			})
+		}
+	})
+	t.Run("Should return error on invalid token response", func(t *testing.T) {
+		for _, body := range []string{
+			`{"access_token":"123","expires_in":"soon"}`,
+			`{"token_type":"Bearer","expires_in":3600}`,
+			`{"access_token":"123","token_type":"foo"}`,
+		} {
+			server := newAuthServer(t, func(url.Values) (int, string) {
+				return http.StatusOK, body
+			})
+			svc := middleware.NewOAuthService(middleware.OAuthConfig{AuthServerURL: server.URL, ClientID: "1"}, server.Client())
+			if _, err := svc.GetToken(); err == nil {
+				t.Errorf("error should be returned for %s", body)
+			}
+		}
+	})
+	t.Run("Should return OAuthError on error response", func(t *testing.T) {
+		server := newAuthServer(t, func(url.Values) (int, string) {
+			return http.StatusUnauthorized, `{"error":"invalid_client","error_description":"client authentication failed"}`
+		})
+		svc := middleware.NewOAuthService(middleware.OAuthConfig{AuthServerURL: server.URL, ClientID: "1"}, server.Client())
+
+		_, err := svc.GetToken()
+		var oauthErr *middleware.OAuthError
+		if !errors.As(err, &oauthErr) {
+			t.Fatalf("expected OAuthError but got %v", err)
+		}
+		if oauthErr.Code != "invalid_client" || oauthErr.Description != "client authentication failed" {
+			t.Errorf("error got %+v", oauthErr)
+		}
+		if client.StatusCode(err) != http.StatusUnauthorized {
+			t.Errorf("status code got %d, want %d", client.StatusCode(err), http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestOAuthTokenType(t *testing.T) {
+	t.Run("Should use authorization scheme of token type", func(t *testing.T) {
+		tests := []struct {
+			body string
+			want string
+		}{
+			{body: `{"access_token":"123","expires_in":3600}`, want: "Bearer 123"},
+			{body: `{"access_token":"123","token_type":"bearer","expires_in":3600}`, want: "Bearer 123"},
+			{body: `{"access_token":"123","token_type":"DPoP","expires_in":3600}`, want: "DPoP 123"},
+		}
+		for _, tt := range tests {
+			authorization, err := authorizationOf(t, tt.body)
+			if err != nil || len(authorization) != 1 || authorization[0] != tt.want {
+				t.Errorf("authorization got %v %v, want %q", authorization, err, tt.want)
+			}
+		}
+	})
+	t.Run("Should sign request with MAC token", func(t *testing.T) {
+		authorization, err := authorizationOf(t, `{"access_token":"id1","token_type":"mac","expires_in":3600,"mac_key":"key","mac_algorithm":"hmac-sha-256"}`)
+		if err != nil || len(authorization) != 1 {
+			t.Fatalf("authorization got %v %v", authorization, err)
+		}
+		m := regexp.MustCompile(`^MAC id="id1", ts="(\d+)", nonce="([^"]+)", mac="([^"]+)"$`).FindStringSubmatch(authorization[0])
+		if m == nil {
+			t.Fatalf("authorization got %q", authorization[0])
+		}
+		mac := hmac.New(sha256.New, []byte("key"))
+		mac.Write([]byte(strings.Join([]string{m[1], m[2], "GET", "/items?id=1", "www.example.com", "443", "", ""}, "\n")))
+		if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); m[3] != want {
+			t.Errorf("mac got %q, want %q", m[3], want)
+		}
+	})
+	t.Run("Should not duplicate Authorization header on retries", func(t *testing.T) {
+		server := newAuthServer(t, nil)
+		var authorization [][]string
+		api := client.NewMockTransport(true)
+		api.RegisterResponder(http.MethodGet, "https://www.example.com", func(request *http.Request) (*http.Response, error) {
+			authorization = append(authorization, request.Header.Values("Authorization"))
+			code := http.StatusOK
+			if len(authorization) == 1 {
+				code = http.StatusServiceUnavailable
+			}
+			return &http.Response{StatusCode: code, Body: http.NoBody, Header: make(http.Header)}, nil
+		})
+		richClient := client.NewClient(api)
+		richClient.Use(
+			middleware.RetryWithConfig(newRetryConfig()),
+			middleware.OAuthWithClient(middleware.OAuthConfig{AuthServerURL: server.URL, ClientID: "1"}, server.Client()),
+		)
+
+		response, err := richClient.Client.Get("https://www.example.com")
+		assertResponse(t, response, err, http.StatusOK, "")
+		if len(authorization) != 2 || len(authorization[1]) != 1 || authorization[1][0] != "Bearer 123" {
+			t.Errorf("authorization got %v", authorization)
+		}
+	})
+}
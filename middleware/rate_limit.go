@@ -0,0 +1,420 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shuvava/go-enrichable-client/client"
+)
+
+/*
+RateLimit is a middleware that throttles outgoing requests on the client side.
+*/
+
+// RateLimitAlgorithm is a type that represents an algorithm of RateLimiterService.
+type RateLimitAlgorithm int
+
+// These constants are algorithms of RateLimiterService.
+const (
+	// RateLimitTokenBucket allows bursts up to RateLimitConfig.Burst requests
+	// and refills tokens at RateLimitConfig.Limit per RateLimitConfig.Window.
+	RateLimitTokenBucket RateLimitAlgorithm = iota
+	// RateLimitSlidingWindow allows RateLimitConfig.Limit requests in any
+	// RateLimitConfig.Window (approximated by weighting the previous window).
+	RateLimitSlidingWindow
+)
+
+// RateLimitMiddlewareName is the name of RateLimit middleware, see client.WithoutMiddleware
+const RateLimitMiddlewareName = "rate-limit"
+
+const defaultRateLimitWindow = time.Second
+
+// ErrRateLimited is returned (wrapped in *RateLimitError) when the request exceeds the rate limit
+// and RateLimitConfig.FailFast is set.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimitError is returned when the request exceeds the rate limit in fail fast mode.
+type RateLimitError struct {
+	// Key is the rate limit key of the request, see RateLimitConfig.KeyFunc
+	Key string
+	// RetryAfter is the estimated time after which the request is allowed
+	RetryAfter time.Duration
+}
+
+// Error implements error interface.
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s for %q, retry after %s", ErrRateLimited, e.Key, e.RetryAfter)
+}
+
+// Is reports whether target is ErrRateLimited.
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// String implements stringer interface.
+func (a RateLimitAlgorithm) String() string {
+	switch a {
+	case RateLimitTokenBucket:
+		return "token-bucket"
+	case RateLimitSlidingWindow:
+		return "sliding-window"
+	default:
+		return fmt.Sprintf("unknown algorithm: %d", a)
+	}
+}
+
+// RateLimitConfig defines the config for RateLimit middleware.
+//
+// Limit is the number of requests allowed per Window for every key.
+// If Limit is 0, requests are limited only by response headers (see Adaptive).
+//
+// Window is the period of Limit. If Window is less than or equal to 0, one second is used.
+//
+// Burst is the capacity of the token bucket. If Burst is 0, Limit is used.
+//
+// KeyFunc returns the key requests are limited by. If KeyFunc is nil, the request URL host is used.
+// The state of a key is dropped when the key is idle long enough to have its full limit again,
+// so KeyFunc may return keys of unbounded set like user ids.
+//
+// FailFast makes the middleware return *RateLimitError instead of waiting for the limit.
+// Otherwise, the middleware waits until the request is allowed or the request context is done.
+//
+// Adaptive makes the middleware follow X-RateLimit-*, RateLimit-* and Retry-After response headers:
+// when the server reports no remaining requests, requests with the same key wait until the reset time.
+type RateLimitConfig struct {
+	Algorithm RateLimitAlgorithm
+	Limit     int
+	Window    time.Duration
+	Burst     int
+	KeyFunc   func(*http.Request) string
+	FailFast  bool
+	Adaptive  bool
+}
+
+// limiter is a rate limit algorithm
+type limiter interface {
+	// take takes a request slot and returns zero or returns the time to wait for a slot
+	take(now time.Time) time.Duration
+	// adapt limits available slots to remaining
+	adapt(remaining int)
+}
+
+// tokenBucket implements RateLimitTokenBucket
+type tokenBucket struct {
+	rate     float64 // tokens per second
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) adapt(remaining int) {
+	b.tokens = math.Min(b.tokens, float64(remaining))
+}
+
+// slidingWindow implements RateLimitSlidingWindow
+type slidingWindow struct {
+	limit    int
+	window   time.Duration
+	start    time.Time
+	previous int
+	current  int
+}
+
+func (w *slidingWindow) advance(now time.Time) {
+	elapsed := now.Sub(w.start)
+	if elapsed < w.window {
+		return
+	}
+	if elapsed < 2*w.window {
+		w.previous = w.current
+	} else {
+		w.previous = 0
+	}
+	w.current = 0
+	w.start = w.start.Add(elapsed / w.window * w.window)
+}
+
+func (w *slidingWindow) take(now time.Time) time.Duration {
+	w.advance(now)
+	elapsed := now.Sub(w.start)
+	weight := 1 - float64(elapsed)/float64(w.window)
+	if float64(w.previous)*weight+float64(w.current) < float64(w.limit) {
+		w.current++
+		return 0
+	}
+	if w.current >= w.limit || w.previous == 0 {
+		return w.window - elapsed
+	}
+	// time until the weighted previous window leaves room for one more request
+	free := float64(w.limit-w.current) / float64(w.previous)
+	wait := time.Duration((weight-free)*float64(w.window)) + time.Millisecond
+	if rest := w.window - elapsed; wait > rest {
+		wait = rest
+	}
+	return wait
+}
+
+func (w *slidingWindow) adapt(remaining int) {
+	if used := w.limit - remaining; used > w.current {
+		w.current = used
+	}
+}
+
+// keyLimiter is the rate limit state of a single key
+type keyLimiter struct {
+	mu      sync.Mutex
+	limiter limiter
+	// blockedUntil is the reset time reported by the server
+	blockedUntil time.Time
+	// used is the time the limiter was last requested, guarded by RateLimiterService.mu
+	used time.Time
+}
+
+func (l *keyLimiter) take(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Before(l.blockedUntil) {
+		return l.blockedUntil.Sub(now)
+	}
+	if l.limiter == nil {
+		return 0
+	}
+	return l.limiter.take(now)
+}
+
+// RateLimiterService limits the rate of requests per key.
+type RateLimiterService struct {
+	config RateLimitConfig
+
+	mu       sync.Mutex
+	limiters map[string]*keyLimiter
+	// idle is the time after which unused limiter has its full limit and can be dropped
+	idle  time.Duration
+	swept time.Time
+}
+
+// NewRateLimiterService returns a new RateLimiterService configured with the given RateLimitConfig.
+func NewRateLimiterService(cfg RateLimitConfig) *RateLimiterService {
+	if cfg.Window <= 0 {
+		cfg.Window = defaultRateLimitWindow
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = cfg.Limit
+	}
+	if cfg.KeyFunc == nil {
+		cfg.KeyFunc = func(r *http.Request) string { return r.URL.Host }
+	}
+	// the token bucket refills in Burst/Limit windows, the sliding window forgets requests in two windows
+	idle := 2 * cfg.Window
+	if cfg.Limit > 0 {
+		if refill := time.Duration(float64(cfg.Window) * float64(cfg.Burst) / float64(cfg.Limit)); refill > idle {
+			idle = refill
+		}
+	}
+	return &RateLimiterService{
+		config:   cfg,
+		limiters: map[string]*keyLimiter{},
+		idle:     idle,
+	}
+}
+
+// WithoutRateLimit disables RateLimit middleware for a single request.
+func WithoutRateLimit() client.RequestOption {
+	return client.WithoutMiddleware(RateLimitMiddlewareName)
+}
+
+func (s *RateLimiterService) limiter(key string, now time.Time) *keyLimiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+	l, ok := s.limiters[key]
+	if ok {
+		l.used = now
+		return l
+	}
+	l = &keyLimiter{used: now}
+	if s.config.Limit > 0 {
+		switch s.config.Algorithm {
+		case RateLimitSlidingWindow:
+			l.limiter = &slidingWindow{limit: s.config.Limit, window: s.config.Window, start: now}
+		default:
+			l.limiter = &tokenBucket{
+				rate:     float64(s.config.Limit) / s.config.Window.Seconds(),
+				capacity: float64(s.config.Burst),
+				tokens:   float64(s.config.Burst),
+				last:     now,
+			}
+		}
+	}
+	s.limiters[key] = l
+	return l
+}
+
+// sweep drops limiters idle for s.idle, it runs at most once per s.idle
+func (s *RateLimiterService) sweep(now time.Time) {
+	if now.Sub(s.swept) < s.idle {
+		return
+	}
+	s.swept = now
+	for key, l := range s.limiters {
+		l.mu.Lock()
+		idle := now.Sub(l.used) >= s.idle && !now.Before(l.blockedUntil)
+		l.mu.Unlock()
+		if idle {
+			delete(s.limiters, key)
+		}
+	}
+}
+
+// Wait blocks until the request with the key is allowed or the request context is done.
+// In fail fast mode it returns *RateLimitError instead of waiting.
+func (s *RateLimiterService) Wait(request *http.Request) error {
+	key := s.config.KeyFunc(request)
+	ctx := request.Context()
+	for {
+		now := time.Now()
+		wait := s.limiter(key, now).take(now)
+		if wait <= 0 {
+			return nil
+		}
+		if s.config.FailFast {
+			return &RateLimitError{Key: key, RetryAfter: wait}
+		}
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(now.Add(wait)) {
+			return fmt.Errorf("%w: %w", &RateLimitError{Key: key, RetryAfter: wait}, context.DeadlineExceeded)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Update adapts the rate limit of the key to the rate limit response headers.
+func (s *RateLimiterService) Update(request *http.Request, resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	now := time.Now()
+	remaining, reset, ok := parseRateLimitHeaders(resp.Header, now)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if after, found := parseRetryAfter(resp.Header.Get("Retry-After"), now); found {
+			remaining, reset, ok = 0, now.Add(after), true
+		}
+	}
+	if !ok {
+		return
+	}
+	l := s.limiter(s.config.KeyFunc(request), now)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limiter != nil && remaining >= 0 {
+		l.limiter.adapt(remaining)
+	}
+	if remaining == 0 && reset.After(l.blockedUntil) {
+		l.blockedUntil = reset
+	}
+}
+
+// Execute process http.Client Do operation
+func (s *RateLimiterService) Execute(_ *http.Client, next client.Responder) client.Responder {
+	return func(request *http.Request) (*http.Response, error) {
+		if err := s.Wait(request); err != nil {
+			return nil, err
+		}
+		resp, err := next(request)
+		if s.config.Adaptive && err == nil {
+			s.Update(request, resp)
+		}
+		return resp, err
+	}
+}
+
+// RateLimit adds rate limit middleware to requests.
+// Place it after Retry middleware to limit every attempt.
+func RateLimit(cfg RateLimitConfig) client.MiddlewareFunc {
+	s := NewRateLimiterService(cfg)
+	return client.Named(RateLimitMiddlewareName, s.Execute)
+}
+
+// parseRateLimitHeaders parses the remaining number of requests and the reset time from
+// RateLimit (structured), RateLimit-* and X-RateLimit-* headers. Remaining is -1 if unknown.
+func parseRateLimitHeaders(h http.Header, now time.Time) (int, time.Time, bool) {
+	remaining, reset := "", ""
+	if v := h.Get("RateLimit"); v != "" {
+		for _, item := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ';' }) {
+			name, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+			switch strings.ToLower(name) {
+			case "r", "remaining":
+				remaining = value
+			case "t", "reset":
+				reset = value
+			}
+		}
+	}
+	for _, prefix := range []string{"RateLimit-", "X-RateLimit-"} {
+		if remaining == "" {
+			remaining = h.Get(prefix + "Remaining")
+		}
+		if reset == "" {
+			reset = h.Get(prefix + "Reset")
+		}
+	}
+	if remaining == "" && reset == "" {
+		return 0, time.Time{}, false
+	}
+	r := -1
+	if n, err := strconv.Atoi(strings.TrimSpace(remaining)); err == nil && n >= 0 {
+		r = n
+	}
+	var t time.Time
+	if n, err := strconv.ParseInt(strings.TrimSpace(reset), 10, 64); err == nil && n >= 0 {
+		if n > 1e9 {
+			// X-RateLimit-Reset is often the unix time of the reset
+			t = time.Unix(n, 0)
+		} else {
+			t = now.Add(time.Duration(n) * time.Second)
+		}
+	}
+	return r, t, true
+}
+
+// parseRetryAfter parses Retry-After header in seconds or HTTP date format
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
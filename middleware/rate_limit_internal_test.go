@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterEviction(t *testing.T) {
+	t.Run("Should drop idle limiters", func(t *testing.T) {
+		s := NewRateLimiterService(RateLimitConfig{Limit: 1, Window: time.Second})
+		now := time.Now()
+		for _, key := range []string{"a", "b", "c"} {
+			s.limiter(key, now).take(now)
+		}
+		s.limiter("a", now.Add(time.Second))
+
+		s.limiter("d", now.Add(2*time.Second))
+		if _, ok := s.limiters["b"]; ok || len(s.limiters) != 2 {
+			t.Errorf("limiters got %d, expected %d", len(s.limiters), 2)
+		}
+	})
+	t.Run("Should keep limiters blocked by the server", func(t *testing.T) {
+		s := NewRateLimiterService(RateLimitConfig{Window: time.Second})
+		now := time.Now()
+		s.limiter("a", now).blockedUntil = now.Add(2 * time.Hour)
+
+		s.limiter("b", now.Add(time.Hour))
+		if _, ok := s.limiters["a"]; !ok {
+			t.Errorf("blocked limiter should be kept")
+		}
+		s.limiter("b", now.Add(3*time.Hour))
+		if _, ok := s.limiters["a"]; ok {
+			t.Errorf("idle limiter should be dropped")
+		}
+	})
+}
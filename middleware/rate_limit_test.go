@@ -0,0 +1,187 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shuvava/go-enrichable-client/client"
+	"github.com/shuvava/go-enrichable-client/middleware"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	t.Run("Should fail fast when limit is exceeded", func(t *testing.T) {
+		for _, algorithm := range []middleware.RateLimitAlgorithm{middleware.RateLimitTokenBucket, middleware.RateLimitSlidingWindow} {
+			t.Run(algorithm.String(), func(t *testing.T) {
+				var (
+					url            = "https://www.example.com"
+					wantStatusCode = http.StatusOK
+					wantBody       = "ok"
+				)
+				m := createGetMock(url, wantStatusCode, wantBody, -1, 0)
+				richClient := client.NewClient(m.mock)
+				richClient.Use(middleware.RateLimit(middleware.RateLimitConfig{
+					Algorithm: algorithm,
+					Limit:     2,
+					Window:    time.Minute,
+					FailFast:  true,
+				}))
+				c := richClient.Client
+
+				for i := 0; i < 2; i++ {
+					response, err := c.Get(url)
+					assertResponse(t, response, err, wantStatusCode, wantBody)
+				}
+				_, err := c.Get(url)
+				if !errors.Is(err, middleware.ErrRateLimited) {
+					t.Fatalf("expected ErrRateLimited but got %v", err)
+				}
+				var rateLimitErr *middleware.RateLimitError
+				if !errors.As(err, &rateLimitErr) || rateLimitErr.Key != "www.example.com" || rateLimitErr.RetryAfter <= 0 {
+					t.Errorf("unexpected error %v", err)
+				}
+				if m.calls != 2 {
+					t.Errorf("calls got %d, expected %d", m.calls, 2)
+				}
+			})
+		}
+	})
+	t.Run("Should wait for the limit", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com"
+			wantStatusCode = http.StatusOK
+			wantBody       = "ok"
+			window         = 100 * time.Millisecond
+		)
+		m := createGetMock(url, wantStatusCode, wantBody, -1, 0)
+		richClient := client.NewClient(m.mock)
+		richClient.Use(middleware.RateLimit(middleware.RateLimitConfig{Limit: 1, Window: window}))
+		c := richClient.Client
+
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			response, err := c.Get(url)
+			assertResponse(t, response, err, wantStatusCode, wantBody)
+		}
+		if elapsed := time.Since(start); elapsed < 2*window-10*time.Millisecond {
+			t.Errorf("elapsed got %s, want at least %s", elapsed, 2*window)
+		}
+	})
+	t.Run("Should stop waiting on context cancellation", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com"
+			wantStatusCode = http.StatusOK
+			wantBody       = "ok"
+		)
+		m := createGetMock(url, wantStatusCode, wantBody, -1, 0)
+		richClient := client.NewClient(m.mock)
+		richClient.Use(middleware.RateLimit(middleware.RateLimitConfig{Limit: 1, Window: time.Hour}))
+		c := richClient.Client
+
+		response, err := c.Get(url)
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		_, err = c.Do(req)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded but got %v", err)
+		}
+	})
+	t.Run("Should limit keys separately", func(t *testing.T) {
+		var (
+			wantStatusCode = http.StatusOK
+			wantBody       = "ok"
+		)
+		mock := client.NewMockTransport(true)
+		for _, url := range []string{"https://a.example.com", "https://b.example.com"} {
+			mock.RegisterResponder(http.MethodGet, url, func(request *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: wantStatusCode,
+					Body:       io.NopCloser(bytes.NewBufferString(wantBody)),
+					Header:     make(http.Header),
+				}, nil
+			})
+		}
+		richClient := client.NewClient(mock)
+		richClient.Use(middleware.RateLimit(middleware.RateLimitConfig{Limit: 1, Window: time.Hour, FailFast: true}))
+		c := richClient.Client
+
+		for _, url := range []string{"https://a.example.com", "https://b.example.com"} {
+			response, err := c.Get(url)
+			assertResponse(t, response, err, wantStatusCode, wantBody)
+		}
+	})
+	t.Run("Should adapt to rate limit headers", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com"
+			wantStatusCode = http.StatusOK
+			wantBody       = "ok"
+		)
+		calls := 0
+		mock := client.NewMockTransport(true)
+		mock.RegisterResponder(http.MethodGet, url, func(request *http.Request) (*http.Response, error) {
+			calls++
+			header := make(http.Header)
+			header.Set("X-RateLimit-Limit", "10")
+			header.Set("X-RateLimit-Remaining", "0")
+			header.Set("X-RateLimit-Reset", "60")
+			return &http.Response{
+				StatusCode: wantStatusCode,
+				Body:       io.NopCloser(bytes.NewBufferString(wantBody)),
+				Header:     header,
+			}, nil
+		})
+		richClient := client.NewClient(mock)
+		richClient.Use(middleware.RateLimit(middleware.RateLimitConfig{Adaptive: true, FailFast: true}))
+		c := richClient.Client
+
+		response, err := c.Get(url)
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+		_, err = c.Get(url)
+		var rateLimitErr *middleware.RateLimitError
+		if !errors.As(err, &rateLimitErr) {
+			t.Fatalf("expected RateLimitError but got %v", err)
+		}
+		if rateLimitErr.RetryAfter < 59*time.Second {
+			t.Errorf("retry after got %s, want about %s", rateLimitErr.RetryAfter, time.Minute)
+		}
+		if calls != 1 {
+			t.Errorf("calls got %d, expected %d", calls, 1)
+		}
+	})
+	t.Run("Should respect Retry-After of 429 response", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com"
+			wantStatusCode = http.StatusTooManyRequests
+		)
+		mock := client.NewMockTransport(true)
+		mock.RegisterResponder(http.MethodGet, url, func(request *http.Request) (*http.Response, error) {
+			header := make(http.Header)
+			header.Set("Retry-After", "30")
+			return &http.Response{
+				StatusCode: wantStatusCode,
+				Body:       io.NopCloser(bytes.NewBufferString("")),
+				Header:     header,
+			}, nil
+		})
+		richClient := client.NewClient(mock)
+		richClient.Use(middleware.RateLimit(middleware.RateLimitConfig{Limit: 100, Adaptive: true, FailFast: true}))
+		c := richClient.Client
+
+		response, err := c.Get(url)
+		assertResponse(t, response, err, wantStatusCode, "")
+		if _, err = c.Get(url); !errors.Is(err, middleware.ErrRateLimited) {
+			t.Errorf("expected ErrRateLimited but got %v", err)
+		}
+		// the request option disables the middleware
+		req, _ := client.NewRequest(context.Background(), http.MethodGet, url, nil, middleware.WithoutRateLimit())
+		response, err = c.Do(req.Request)
+		assertResponse(t, response, err, wantStatusCode, "")
+	})
+}
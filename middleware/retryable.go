@@ -19,6 +19,9 @@ const (
 	defaultRetryWaitMax = 30 * time.Second
 	defaultRetryMax     = 3
 
+	// RetryMiddlewareName is the name of Retry middleware, see client.WithoutMiddleware
+	RetryMiddlewareName = "retry"
+
 	// We need to consume response bodies to maintain http connections, but
 	// limit the size we consume to respBodyReadLimit.
 	respBodyReadLimit = 1024
@@ -73,6 +76,89 @@ var (
 	}
 )
 
+type retryConfigKey struct{}
+
+type attemptKey struct{}
+
+type attemptHookKey struct{}
+
+// attemptHook prepares the request of each attempt of Retry middleware. It returns the request
+// to send and optional function called with the result of the attempt.
+type attemptHook func(*http.Request) (*http.Request, func(*http.Response, error), error)
+
+// AttemptFromContext returns the number of the attempt made by Retry middleware starting from 1.
+// It returns 1 for requests not processed by Retry middleware.
+func AttemptFromContext(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+// withAttemptHook returns ctx with the hook called by Retry middleware before each attempt,
+// so the middleware running before Retry can handle every attempt. Hooks run in the order
+// they are added, their results are reported in reverse order.
+func withAttemptHook(ctx context.Context, hook attemptHook) context.Context {
+	if prev, ok := ctx.Value(attemptHookKey{}).(attemptHook); ok {
+		next := hook
+		hook = func(r *http.Request) (*http.Request, func(*http.Response, error), error) {
+			r, prevDone, err := prev(r)
+			if err != nil {
+				return r, prevDone, err
+			}
+			r, nextDone, err := next(r)
+			return r, func(resp *http.Response, err error) {
+				if nextDone != nil {
+					nextDone(resp, err)
+				}
+				if prevDone != nil {
+					prevDone(resp, err)
+				}
+			}, err
+		}
+	}
+	return context.WithValue(ctx, attemptHookKey{}, hook)
+}
+
+// runAttemptHook calls the hook of the request context if any.
+// The returned function must be called with the result of the attempt.
+func runAttemptHook(r *http.Request) (*http.Request, func(*http.Response, error), error) {
+	hook, ok := r.Context().Value(attemptHookKey{}).(attemptHook)
+	if !ok {
+		return r, func(*http.Response, error) {}, nil
+	}
+	r, done, err := hook(r)
+	if done == nil {
+		done = func(*http.Response, error) {}
+	}
+	return r, done, err
+}
+
+// WithRetryConfig overrides RetryConfig of Retry middleware for a single request.
+func WithRetryConfig(config RetryConfig) client.RequestOption {
+	return client.WithContextValue(retryConfigKey{}, config)
+}
+
+// WithoutRetry disables Retry middleware for a single request,
+// e.g. for a non-idempotent POST request.
+func WithoutRetry() client.RequestOption {
+	return client.WithoutMiddleware(RetryMiddlewareName)
+}
+
+// retryConfigFromContext returns RetryConfig override from ctx or config
+func retryConfigFromContext(ctx context.Context, config RetryConfig) RetryConfig {
+	if c, ok := ctx.Value(retryConfigKey{}).(RetryConfig); ok {
+		config = c
+	}
+	if config.CheckRetry == nil {
+		config.CheckRetry = DefaultRetryPolicy
+	}
+	if config.Backoff == nil {
+		config.Backoff = DefaultBackoff
+	}
+	return config
+}
+
 // SetRequestHook set a user-supplied function to be called
 // with each HTTP request executed.
 func (c *RetryConfig) SetRequestHook(hook RequestHook) {
@@ -102,6 +188,11 @@ func DefaultRetryPolicy(ctx context.Context, resp *http.Response, err error) (bo
 		return true, nil
 	}
 
+	// do not retry responses which are successful according to client.StatusPolicy
+	if client.ClassifyResponse(resp) != client.StatusClassError {
+		return false, nil
+	}
+
 	// 429 Too Many Requests is recoverable.
 	if resp.StatusCode == http.StatusTooManyRequests {
 		return true, nil
@@ -200,10 +291,12 @@ func Retry() client.MiddlewareFunc {
 	return RetryWithConfig(c)
 }
 
-// RetryWithConfig creates retry middleware with config
-func RetryWithConfig(config RetryConfig) client.MiddlewareFunc {
-	return func(c *http.Client, next client.Responder) client.Responder {
+// RetryWithConfig creates retry middleware with config.
+// The config can be overridden for a single request by WithRetryConfig.
+func RetryWithConfig(defaultConfig RetryConfig) client.MiddlewareFunc {
+	return client.Named(RetryMiddlewareName, func(c *http.Client, next client.Responder) client.Responder {
 		return func(request *http.Request) (*http.Response, error) {
+			config := retryConfigFromContext(request.Context(), defaultConfig)
 			var resp *http.Response
 			var shouldRetry bool
 			var attempt int
@@ -224,7 +317,12 @@ func RetryWithConfig(config RetryConfig) client.MiddlewareFunc {
 					config.RequestHook(req.Request)
 				}
 
-				resp, doErr = next(request)
+				attemptReq, endAttempt, hookErr := runAttemptHook(request.WithContext(context.WithValue(request.Context(), attemptKey{}, attempt)))
+				resp, doErr = nil, hookErr
+				if doErr == nil {
+					resp, doErr = next(attemptReq)
+				}
+				endAttempt(resp, doErr)
 
 				// Check if we should continue with retries.
 				shouldRetry, checkErr = config.CheckRetry(req.Context(), resp, doErr)
@@ -270,5 +368,5 @@ func RetryWithConfig(config RetryConfig) client.MiddlewareFunc {
 			return nil, fmt.Errorf("%s %s giving up after %d attempt(s): %w",
 				req.Method, req.URL, attempt, err)
 		}
-	}
+	})
 }
@@ -1,6 +1,7 @@
 package middleware_test
 
 import (
+	"context"
 	"net/http"
 	"testing"
 
@@ -94,3 +95,90 @@ func newRetryConfig() middleware.RetryConfig {
 		Backoff:      middleware.DefaultBackoff,
 	}
 }
+
+func TestRetryableMiddlewareStatusPolicy(t *testing.T) {
+	t.Run("Should not retry response successful by client StatusPolicy", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com"
+			wantStatusCode = http.StatusServiceUnavailable
+			wantBody       = `error`
+		)
+		m := createGetMock(url, wantStatusCode, wantBody, -1, 0)
+		richClient := client.NewClient(m.mock)
+		richClient.StatusPolicy = func(resp *http.Response) client.StatusClass {
+			if resp.StatusCode == http.StatusServiceUnavailable {
+				return client.StatusClassEmptySuccess
+			}
+			return client.DefaultStatusPolicy(resp)
+		}
+		richClient.Use(middleware.RetryWithConfig(newRetryConfig()))
+		c := richClient.Client
+
+		response, err := c.Get(url)
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+
+		if m.calls != 1 {
+			t.Errorf("retry got %d, expected %d", m.calls, 1)
+		}
+	})
+}
+
+func TestRetryableMiddlewareRequestOptions(t *testing.T) {
+	var (
+		url            = "https://www.example.com"
+		wantStatusCode = http.StatusInternalServerError
+		wantBody       = `error`
+	)
+	t.Run("Should not retry when disabled for request", func(t *testing.T) {
+		m := createPostMock(url, wantStatusCode, wantBody, -1, 0)
+		richClient := client.NewClient(m.mock)
+		richClient.Use(middleware.RetryWithConfig(newRetryConfig()))
+
+		req, err := client.NewHTTPRequest(context.Background(), http.MethodPost, url, nil, middleware.WithoutRetry())
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		response, err := richClient.Client.Do(req)
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+
+		if m.calls != 1 {
+			t.Errorf("retry got %d, expected %d", m.calls, 1)
+		}
+	})
+	t.Run("Should use retry config of request", func(t *testing.T) {
+		m := createGetMock(url, wantStatusCode, wantBody, -1, 0)
+		richClient := client.NewClient(m.mock)
+		richClient.Use(middleware.RetryWithConfig(newRetryConfig()))
+
+		config := newRetryConfig()
+		config.RetryMax = 1
+		req, err := client.NewHTTPRequest(context.Background(), http.MethodGet, url, nil, middleware.WithRetryConfig(config))
+		if err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		response, err := richClient.Client.Do(req)
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+
+		if m.calls != 2 {
+			t.Errorf("retry got %d, expected %d", m.calls, 2)
+		}
+	})
+}
+
+func TestRetryableMiddlewareName(t *testing.T) {
+	t.Run("Should reorder Retry registered under its name", func(t *testing.T) {
+		m := createGetMock("https://www.example.com", http.StatusOK, "", -1, 0)
+		richClient := client.NewClient(m.mock)
+		if err := richClient.UseNamed(middleware.RetryMiddlewareName, middleware.Retry()); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if err := richClient.Before(middleware.RetryMiddlewareName, "auth", func(c *http.Client, next client.Responder) client.Responder {
+			return next
+		}); err != nil {
+			t.Fatalf("did not expect an error but got one %v", err)
+		}
+		if got := richClient.Middlewares(); len(got) != 2 || got[0] != "auth" || got[1] != middleware.RetryMiddlewareName {
+			t.Errorf("middlewares got %v", got)
+		}
+	})
+}
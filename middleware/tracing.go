@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/shuvava/go-enrichable-client/client"
+)
+
+/*
+Tracing is a middleware that creates OpenTelemetry client spans for outgoing requests
+and propagates the trace context to the server.
+*/
+
+const (
+	// TracingMiddlewareName is the name of Tracing middleware, see client.WithoutMiddleware
+	TracingMiddlewareName = "tracing"
+
+	tracerName = "github.com/shuvava/go-enrichable-client/middleware"
+)
+
+// TracingConfig defines the config for Tracing middleware.
+type TracingConfig struct {
+	// TracerProvider creates the tracer. If TracerProvider is nil, otel.GetTracerProvider() is used.
+	TracerProvider trace.TracerProvider
+	// Propagator injects the trace context into request headers.
+	// If Propagator is nil, W3C Trace Context (traceparent, tracestate) is used.
+	Propagator propagation.TextMapPropagator
+	// SpanName returns the span name of the request. If SpanName is nil, the request method is used.
+	SpanName func(*http.Request) string
+	// Attributes are added to every span.
+	Attributes []attribute.KeyValue
+	// RedactQueryParams is a list of query parameters whose values are redacted in url.full attribute
+	// and error messages. If RedactQueryParams is nil, DefaultRedactParams is used.
+	RedactQueryParams []string
+}
+
+// tracing is the state of Tracing middleware shared with Retry middleware through the request context
+type tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	config     TracingConfig
+	redactor   *redactor
+}
+
+// start starts a client span of the request and returns the request with the trace context
+// of the span in the context and headers
+func (t *tracing) start(request *http.Request, name string, attrs ...attribute.KeyValue) (*http.Request, trace.Span) {
+	ctx, span := t.tracer.Start(request.Context(), name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(t.requestAttributes(request)...),
+		trace.WithAttributes(t.config.Attributes...),
+		trace.WithAttributes(attrs...),
+	)
+	// do not modify the caller's request
+	req := request.WithContext(ctx)
+	req.Header = request.Header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return req, span
+}
+
+func (t *tracing) spanName(request *http.Request) string {
+	if t.config.SpanName != nil {
+		return t.config.SpanName(request)
+	}
+	return request.Method
+}
+
+// startAttempt is the attempt hook of Retry middleware starting a child span per attempt.
+// The returned function ends the span.
+func (t *tracing) startAttempt(request *http.Request) (*http.Request, func(*http.Response, error), error) {
+	var attrs []attribute.KeyValue
+	if attempt := AttemptFromContext(request.Context()); attempt > 1 {
+		attrs = append(attrs, semconv.HTTPRequestResendCount(attempt-1))
+	}
+	req, span := t.start(request, t.spanName(request), attrs...)
+	return req, func(resp *http.Response, err error) {
+		t.end(span, resp, err)
+	}, nil
+}
+
+// requestAttributes returns attributes of the request following OpenTelemetry HTTP semantic conventions
+// with redacted userinfo and sensitive query parameters of url.full
+func (t *tracing) requestAttributes(request *http.Request) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{semconv.HTTPRequestMethodKey.String(request.Method)}
+	u := request.URL
+	if u == nil {
+		return attrs
+	}
+	attrs = append(attrs, semconv.URLFull(t.redactor.url(u)))
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host = u.Host
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+	if host != "" {
+		attrs = append(attrs, semconv.ServerAddress(host))
+	}
+	if p, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, semconv.ServerPort(p))
+	}
+	return attrs
+}
+
+// end records the result of the request and ends the span.
+// The error message is redacted as it may contain the request URL.
+func (t *tracing) end(span trace.Span, resp *http.Response, err error) {
+	defer span.End()
+	if err != nil {
+		errType, message := fmt.Sprintf("%T", err), t.redactor.error(err)
+		span.AddEvent("exception", trace.WithAttributes(semconv.ExceptionType(errType), semconv.ExceptionMessage(message)))
+		span.SetAttributes(semconv.ErrorTypeKey.String(errType))
+		span.SetStatus(codes.Error, message)
+		return
+	}
+	if resp == nil {
+		return
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if client.ClassifyResponse(resp) == client.StatusClassError {
+		span.SetAttributes(semconv.ErrorTypeKey.String(strconv.Itoa(resp.StatusCode)))
+		span.SetStatus(codes.Error, resp.Status)
+	}
+}
+
+// Tracing creates a middleware starting a client span per logical request.
+// Retry middleware placed after Tracing middleware starts a child span per attempt.
+// The trace context of the innermost span is injected into the request headers.
+func Tracing(cfg TracingConfig) client.MiddlewareFunc {
+	provider := cfg.TracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	t := &tracing{
+		tracer:     provider.Tracer(tracerName, trace.WithSchemaURL(semconv.SchemaURL)),
+		propagator: cfg.Propagator,
+		config:     cfg,
+		redactor:   newRedactor(LoggingConfig{RedactQueryParams: cfg.RedactQueryParams, RedactJSONFields: []string{}}),
+	}
+	if t.propagator == nil {
+		t.propagator = propagation.TraceContext{}
+	}
+	return client.Named(TracingMiddlewareName, func(c *http.Client, next client.Responder) client.Responder {
+		return func(request *http.Request) (*http.Response, error) {
+			req, span := t.start(request, t.spanName(request))
+			req = req.WithContext(withAttemptHook(req.Context(), t.startAttempt))
+			resp, err := next(req)
+			t.end(span, resp, err)
+			return resp, err
+		}
+	})
+}
@@ -0,0 +1,187 @@
+package middleware_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/shuvava/go-enrichable-client/client"
+	"github.com/shuvava/go-enrichable-client/middleware"
+)
+
+func newTracerProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), exporter
+}
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	t.Run("Should create client span and inject traceparent", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com/users"
+			wantStatusCode = http.StatusOK
+			wantBody       = "ok"
+		)
+		var traceparent string
+		mock := client.NewMockTransport(true)
+		mock.RegisterResponder(http.MethodGet, url, func(request *http.Request) (*http.Response, error) {
+			traceparent = request.Header.Get("traceparent")
+			return &http.Response{
+				StatusCode: wantStatusCode,
+				Body:       io.NopCloser(bytes.NewBufferString(wantBody)),
+				Header:     make(http.Header),
+			}, nil
+		})
+		provider, exporter := newTracerProvider()
+		richClient := client.NewClient(mock)
+		richClient.Use(middleware.Tracing(middleware.TracingConfig{TracerProvider: provider}))
+
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		response, err := richClient.Client.Do(req)
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+
+		spans := exporter.GetSpans()
+		if len(spans) != 1 {
+			t.Fatalf("spans got %d, want %d", len(spans), 1)
+		}
+		span := spans[0]
+		if span.Name != http.MethodGet || span.SpanKind != trace.SpanKindClient {
+			t.Errorf("span got %q %s", span.Name, span.SpanKind)
+		}
+		wantTraceparent := "00-" + span.SpanContext.TraceID().String() + "-" + span.SpanContext.SpanID().String() + "-01"
+		if traceparent != wantTraceparent {
+			t.Errorf("traceparent got %q, want %q", traceparent, wantTraceparent)
+		}
+		if req.Header.Get("traceparent") != "" {
+			t.Error("request header should not be modified")
+		}
+		if v, _ := spanAttribute(span, "http.response.status_code"); v.AsInt64() != int64(wantStatusCode) {
+			t.Errorf("status code got %v, want %d", v, wantStatusCode)
+		}
+		if v, _ := spanAttribute(span, "server.address"); v.AsString() != "www.example.com" {
+			t.Errorf("server.address got %v", v)
+		}
+		if v, _ := spanAttribute(span, "server.port"); v.AsInt64() != 443 {
+			t.Errorf("server.port got %v", v)
+		}
+		if span.Status.Code != codes.Unset {
+			t.Errorf("status got %v, want %v", span.Status.Code, codes.Unset)
+		}
+	})
+	t.Run("Should create child span per retry attempt", func(t *testing.T) {
+		var (
+			url            = "https://www.example.com"
+			wantStatusCode = http.StatusOK
+			wantBody       = "ok"
+		)
+		m := createGetMock(url, wantStatusCode, wantBody, 2, http.StatusServiceUnavailable)
+		provider, exporter := newTracerProvider()
+		richClient := client.NewClient(m.mock)
+		richClient.Use(
+			middleware.Tracing(middleware.TracingConfig{TracerProvider: provider}),
+			middleware.RetryWithConfig(newRetryConfig()),
+		)
+
+		response, err := richClient.Client.Get(url)
+		assertResponse(t, response, err, wantStatusCode, wantBody)
+
+		spans := exporter.GetSpans()
+		if len(spans) != 4 {
+			t.Fatalf("spans got %d, want %d", len(spans), 4)
+		}
+		// attempts end before the logical request
+		parent := spans[3]
+		for i, span := range spans[:3] {
+			if span.Parent.SpanID() != parent.SpanContext.SpanID() {
+				t.Errorf("attempt %d parent got %s, want %s", i+1, span.Parent.SpanID(), parent.SpanContext.SpanID())
+			}
+			resend, ok := spanAttribute(span, "http.request.resend_count")
+			if i == 0 && ok {
+				t.Errorf("first attempt should not have resend count, got %v", resend)
+			}
+			if i > 0 && resend.AsInt64() != int64(i) {
+				t.Errorf("resend count got %v, want %d", resend, i)
+			}
+		}
+		if spans[0].Status.Code != codes.Error {
+			t.Errorf("failed attempt status got %v, want %v", spans[0].Status.Code, codes.Error)
+		}
+		if v, _ := spanAttribute(spans[0], "error.type"); v.AsString() != "503" {
+			t.Errorf("error.type got %v, want %s", v, "503")
+		}
+		if parent.Status.Code != codes.Unset {
+			t.Errorf("request status got %v, want %v", parent.Status.Code, codes.Unset)
+		}
+	})
+	t.Run("Should record transport error", func(t *testing.T) {
+		provider, exporter := newTracerProvider()
+		richClient := client.NewClient(client.NewMockTransport(true))
+		richClient.Use(middleware.Tracing(middleware.TracingConfig{TracerProvider: provider}))
+
+		_, err := richClient.Client.Get("https://www.example.com/unknown")
+		if err == nil {
+			t.Fatal("expected an error but got none")
+		}
+		spans := exporter.GetSpans()
+		if len(spans) != 1 {
+			t.Fatalf("spans got %d, want %d", len(spans), 1)
+		}
+		if spans[0].Status.Code != codes.Error {
+			t.Errorf("status got %v, want %v", spans[0].Status.Code, codes.Error)
+		}
+		if len(spans[0].Events) == 0 {
+			t.Error("expected exception event")
+		}
+	})
+	t.Run("Should redact query parameters", func(t *testing.T) {
+		provider, exporter := newTracerProvider()
+		richClient := client.NewClient(client.NewMockTransport(true))
+		richClient.Use(
+			middleware.Tracing(middleware.TracingConfig{TracerProvider: provider}),
+			middleware.RetryWithConfig(newRetryConfig()),
+		)
+
+		if _, err := richClient.Client.Get("https://www.example.com/unknown?access_token=secret&id=1"); err == nil {
+			t.Fatal("expected an error but got none")
+		}
+		spans := exporter.GetSpans()
+		if len(spans) != defaultRetryMax+2 {
+			t.Fatalf("spans got %d, want %d", len(spans), defaultRetryMax+2)
+		}
+		for _, span := range spans {
+			if v, _ := spanAttribute(span, "url.full"); v.AsString() != "https://www.example.com/unknown?access_token=[REDACTED]&id=1" {
+				t.Errorf("url.full got %v", v.AsString())
+			}
+			if strings.Contains(span.Status.Description, "secret") {
+				t.Errorf("status got %q", span.Status.Description)
+			}
+			for _, event := range span.Events {
+				for _, kv := range event.Attributes {
+					if strings.Contains(kv.Value.Emit(), "secret") {
+						t.Errorf("event attribute %s got %q", kv.Key, kv.Value.Emit())
+					}
+				}
+			}
+		}
+		// the span of the logical request ends last with the error of Retry middleware
+		if status := spans[len(spans)-1].Status.Description; !strings.Contains(status, "access_token=[REDACTED]") {
+			t.Errorf("status got %q", status)
+		}
+	})
+}
@@ -11,6 +11,9 @@ import (
 UserAgent is a middleware that add the user agent string into request http.Header.
 */
 
+// UserAgentMiddlewareName is the name of UserAgent middleware, see client.WithoutMiddleware
+const UserAgentMiddlewareName = "user-agent"
+
 // UserAgentConfig defines the config for UserAgent middleware.
 type UserAgentConfig struct {
 	// App is the name of the application
@@ -22,10 +25,10 @@ type UserAgentConfig struct {
 // UserAgent is a middleware that parses the user agent string into http.Header.
 func UserAgent(cfg UserAgentConfig) client.MiddlewareFunc {
 	userAgent := fmt.Sprintf("%s/%s", cfg.App, cfg.Version)
-	return func(c *http.Client, next client.Responder) client.Responder {
+	return client.Named(UserAgentMiddlewareName, func(c *http.Client, next client.Responder) client.Responder {
 		return func(request *http.Request) (*http.Response, error) {
 			request.Header.Set("User-Agent", userAgent)
 			return next(request)
 		}
-	}
+	})
 }